@@ -3,20 +3,24 @@ package main
 import (
 	//"errors"
 
+	"encoding/json"
 	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
 	vault "github.com/mch1307/vaultlib"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	yaml "gopkg.in/yaml.v2"
 )
 
 const envVaultAddr = "GVS_VAULTURL"
@@ -29,6 +33,49 @@ const envVaultRoleID = "GVS_VAULTROLEID"
 const envVaultSecretID = "GVS_VAULTSECRETID"
 const envOutputFormat = "GVS_OUTPUTFORMAT"
 const envLogLevel = "GVS_LOGLEVEL"
+const envRefreshInterval = "GVS_REFRESHINTERVAL"
+const envReloadSignal = "GVS_RELOADSIGNAL"
+const envDeleteOnRead = "GVS_DELETEONREAD"
+const envOutputPretty = "GVS_OUTPUTPRETTY"
+const envTemplatePath = "GVS_TEMPLATEPATH"
+const envSecretTargetType = "GVS_SECRETTARGETTYPE"
+const envSocketAllowedUIDs = "GVS_SOCKETALLOWEDUIDS"
+const envSocketAllowedPIDs = "GVS_SOCKETALLOWEDPIDS"
+const envTargetsFile = "GVS_TARGETSFILE"
+const envSecretConflict = "GVS_SECRETCONFLICT"
+const envSecretPathTemplate = "GVS_SECRETPATHTEMPLATE"
+const envSecretMetadata = "GVS_SECRETMETADATA"
+const envKeyMap = "GVS_KEYMAP"
+const envKeyPrefix = "GVS_KEYPREFIX"
+const envKeyUppercase = "GVS_KEYUPPERCASE"
+const envFlatten = "GVS_FLATTEN"
+const envBase64Keys = "GVS_BASE64KEYS"
+const envBase64Suffix = "GVS_BASE64SUFFIX"
+const envSchemaFile = "GVS_SCHEMAFILE"
+const envAuthMethod = "GVS_AUTHMETHOD"
+const envAuthRole = "GVS_AUTHROLE"
+const envAuthMount = "GVS_AUTHMOUNT"
+const envK8sTokenPath = "GVS_K8STOKENPATH"
+const envVaultToken = "VAULT_TOKEN"
+const envVaultTokenPath = "GVS_VAULTTOKENPATH"
+const envJWTPath = "GVS_JWTPATH"
+const envTLSCert = "GVS_TLSCERT"
+const envTLSKey = "GVS_TLSKEY"
+const envCACert = "GVS_CACERT"
+const envSecretIDWrapped = "GVS_SECRETIDWRAPPED"
+const envTokenFilePath = "GVS_TOKENFILEPATH"
+const envTokenWrapTTL = "GVS_TOKENWRAPTTL"
+const envTokenEnv = "GVS_TOKENENV"
+const envVaultAddress = "VAULT_ADDR"
+const envKeepToken = "GVS_KEEPTOKEN"
+
+const formatYAML = "yaml"
+const formatKV = "kv"
+const formatJSON = "json"
+const formatDotenv = "dotenv"
+const formatShell = "shell"
+const formatTemplate = "template"
+const formatDir = "dir"
 
 // holds our config
 type gvs struct {
@@ -36,15 +83,64 @@ type gvs struct {
 	AppEnv              string
 	VaultURL            string
 	VaultSecretPath     string
+	VaultSecretPaths    []string
+	SecretPathTemplate  string
+	SecretConflict      string
+	SecretMetadata      string
+	SecretsMetadata     []secretMetadata
+	KeyMap              []keyMapping
+	KeyPrefix           string
+	KeyUppercase        bool
+	Flatten             string
+	Base64Keys          []string
+	Base64Suffix        string
+	SchemaFile          string
+	Schema              *secretSchema
 	VaultRoleID         string
 	VaultSecretID       string
 	SecretFilePath      string
 	SecretAvailabletime string
 	VaultToken          string
+	AuthMethod          string
+	AuthRole            string
+	AuthMount           string
+	K8sTokenPath        string
+	VaultTokenPath      string
+	JWTPath             string
+	TLSCert             string
+	TLSKey              string
+	CACert              string
+	SecretIDWrapped     bool
+	TokenFilePath       string
+	TokenWrapTTL        string
+	TokenEnv            bool
+	TokenHandedOff      bool
+	TokenRevoked        bool
+	KeepToken           bool
+	Leases              []string
 	OutputFormat        string
+	OutputPretty        bool
+	TemplatePath        string
+	Template            *template.Template
 	LogLevel            string
-	VaultConfig         *vault.Config
-	VaultCli            *vault.Client
+	RefreshInterval     string
+	ReloadSignal        string
+	DeleteOnRead        bool
+	SecretTargetType    string
+	SocketAllowedUIDs   []int
+	SocketAllowedPIDs   []int
+	TargetsFile         string
+	Targets             []*secretTarget
+	// carries the login token, the Vault requests using HTTPCli and TokenInfo
+	VaultConfig *vault.Config
+	// unused, kept as the existing test literals set it
+	VaultCli  *vault.Client
+	HTTPCli   *http.Client
+	TokenInfo *vault.VaultTokenInfo
+	tokenLock sync.Mutex
+	// dynamic secrets by path, and the leases they superseded
+	dynamicSecrets map[string]*dynamicSecret
+	staleLeases    []staleLease
 }
 
 func errInfo() (info string) {
@@ -55,25 +151,58 @@ func errInfo() (info string) {
 	return frame.Function + ":" + strconv.Itoa(frame.Line)
 }
 
-//var gvs gvs
+// var gvs gvs
 var version string
 
 // Init read env and initialize app config
 func newGVS() (*gvs, error) {
 
 	gvs := new(gvs)
+	var err error
 
 	// get config from env
 	gvs.AppName = os.Getenv(envAppName)
 	gvs.AppEnv = os.Getenv(envAppEnv)
 	gvs.VaultURL = os.Getenv(envVaultAddr)
-	gvs.VaultSecretPath = strings.TrimSuffix(strings.TrimPrefix(os.Getenv(envSecretPath), "/"), "/")
+	gvs.VaultSecretPaths = parseSecretPaths(os.Getenv(envSecretPath))
+	gvs.VaultSecretPath = strings.Join(gvs.VaultSecretPaths, ",")
+	gvs.SecretPathTemplate = os.Getenv(envSecretPathTemplate)
+	gvs.SecretConflict = strings.ToLower(os.Getenv(envSecretConflict))
+	gvs.SecretMetadata = strings.ToLower(os.Getenv(envSecretMetadata))
+	gvs.KeyPrefix = os.Getenv(envKeyPrefix)
+	gvs.KeyUppercase, _ = strconv.ParseBool(os.Getenv(envKeyUppercase))
+	gvs.Flatten = strings.ToLower(os.Getenv(envFlatten))
+	gvs.Base64Keys = parseList(os.Getenv(envBase64Keys))
+	gvs.Base64Suffix = os.Getenv(envBase64Suffix)
+	gvs.SchemaFile = os.Getenv(envSchemaFile)
 	gvs.SecretAvailabletime = os.Getenv(envSecretAvailableTime)
 	gvs.VaultRoleID = os.Getenv(envVaultRoleID)
 	gvs.VaultSecretID = os.Getenv(envVaultSecretID)
+	gvs.AuthMethod = strings.ToLower(os.Getenv(envAuthMethod))
+	gvs.AuthRole = os.Getenv(envAuthRole)
+	gvs.AuthMount = os.Getenv(envAuthMount)
+	gvs.K8sTokenPath = os.Getenv(envK8sTokenPath)
+	gvs.VaultToken = os.Getenv(envVaultToken)
+	gvs.VaultTokenPath = os.Getenv(envVaultTokenPath)
+	gvs.JWTPath = os.Getenv(envJWTPath)
+	gvs.TLSCert = os.Getenv(envTLSCert)
+	gvs.TLSKey = os.Getenv(envTLSKey)
+	gvs.CACert = os.Getenv(envCACert)
+	gvs.SecretIDWrapped, _ = strconv.ParseBool(os.Getenv(envSecretIDWrapped))
+	gvs.TokenFilePath = os.Getenv(envTokenFilePath)
+	gvs.TokenWrapTTL = os.Getenv(envTokenWrapTTL)
+	gvs.TokenEnv, _ = strconv.ParseBool(os.Getenv(envTokenEnv))
+	gvs.KeepToken, _ = strconv.ParseBool(os.Getenv(envKeepToken))
 	gvs.SecretFilePath = os.Getenv(envSecretFilePath)
-	gvs.OutputFormat = os.Getenv(envOutputFormat)
+	gvs.OutputFormat = strings.ToLower(os.Getenv(envOutputFormat))
+	gvs.OutputPretty, _ = strconv.ParseBool(os.Getenv(envOutputPretty))
+	gvs.TemplatePath = os.Getenv(envTemplatePath)
 	gvs.LogLevel = os.Getenv(envLogLevel)
+	gvs.RefreshInterval = os.Getenv(envRefreshInterval)
+	gvs.ReloadSignal = os.Getenv(envReloadSignal)
+	gvs.DeleteOnRead, _ = strconv.ParseBool(os.Getenv(envDeleteOnRead))
+	gvs.SecretTargetType = strings.ToLower(os.Getenv(envSecretTargetType))
+	gvs.TargetsFile = os.Getenv(envTargetsFile)
 
 	// initialize logger
 	log.SetFormatter(&log.TextFormatter{})
@@ -109,31 +238,140 @@ func newGVS() (*gvs, error) {
 		gvs.VaultRoleID = "/run/secrets/role_id"
 	}
 
-	if len(gvs.OutputFormat) == 0 {
-		gvs.OutputFormat = "yaml"
+	switch gvs.OutputFormat {
+	case "":
+		gvs.OutputFormat = formatYAML
+	case formatYAML, formatKV, formatJSON, formatDotenv, formatShell, formatDir:
+	case formatTemplate:
+		if gvs.Template, err = newSecretTemplate(gvs.TemplatePath); err != nil {
+			return gvs, errors.New("Error reading template: " + err.Error())
+		}
+	default:
+		return gvs, errors.New("Unsupported output format " + gvs.OutputFormat)
 	}
 
-	// get Vault App Role credentials
-	vaultRoleID, err := getSecretFromFile(gvs.VaultRoleID)
-	if err != nil {
-		return gvs, errors.New("Error reading role_id secret: " + err.Error())
+	// build the secret path from the app name and env when not specified
+	if len(gvs.VaultSecretPaths) == 0 {
+		if len(gvs.SecretPathTemplate) == 0 {
+			gvs.SecretPathTemplate = defaultSecretPathTemplate
+		}
+		secretPath, err := buildSecretPath(gvs.SecretPathTemplate, gvs.AppName, gvs.AppEnv)
+		if err != nil {
+			return gvs, errors.New("Error building secret path: " + err.Error())
+		}
+		gvs.VaultSecretPaths = parseSecretPaths(secretPath)
+		gvs.VaultSecretPath = strings.Join(gvs.VaultSecretPaths, ",")
+		log.Debugf("Secret path built from template: %v", secretPath)
+	}
+	if len(gvs.VaultSecretPaths) == 0 {
+		return gvs, errors.New("No secret path provided")
+	}
+	for _, p := range gvs.VaultSecretPaths {
+		if _, _, err := splitSecretVersion(p); err != nil {
+			return gvs, errors.New("Error reading secret path: " + err.Error())
+		}
+	}
+
+	switch gvs.SecretConflict {
+	case "":
+		gvs.SecretConflict = conflictOverride
+	case conflictOverride, conflictError, conflictFirst:
+	default:
+		return gvs, errors.New("Unsupported secret conflict policy " + gvs.SecretConflict)
+	}
+
+	if gvs.KeyMap, err = parseKeyMap(os.Getenv(envKeyMap)); err != nil {
+		return gvs, errors.New("Error reading key map: " + err.Error())
+	}
+
+	switch gvs.Flatten {
+	case "":
+		gvs.Flatten = flattenDot
+	case flattenDot, flattenUnderscore:
+	default:
+		return gvs, errors.New("Unsupported flatten strategy " + gvs.Flatten)
+	}
+
+	switch gvs.SecretMetadata {
+	case "":
+		gvs.SecretMetadata = metadataNone
+	case metadataNone, metadataInline, metadataFile:
+	default:
+		return gvs, errors.New("Unsupported secret metadata output " + gvs.SecretMetadata)
+	}
+
+	if numSec, err := strconv.Atoi(gvs.RefreshInterval); err != nil || numSec < 1 {
+		gvs.RefreshInterval = "300"
+	}
+
+	if len(gvs.ReloadSignal) > 0 {
+		if _, err := parseSignal(gvs.ReloadSignal); err != nil {
+			return gvs, errors.New("Error reading reload signal: " + err.Error())
+		}
+	}
+
+	switch gvs.SecretTargetType {
+	case "":
+		gvs.SecretTargetType = targetFile
+	case targetFile, targetSocket, targetFifo:
+	default:
+		return gvs, errors.New("Unsupported secret target type " + gvs.SecretTargetType)
+	}
+
+	if gvs.OutputFormat == formatDir && (gvs.SecretTargetType != targetFile || gvs.DeleteOnRead) {
+		return gvs, errors.New("dir output format is only supported for file targets, without delete on read")
 	}
 
-	vaultSecretID, err := getSecretFromFile(gvs.VaultSecretID)
+	if gvs.SocketAllowedUIDs, err = parseIDList(os.Getenv(envSocketAllowedUIDs)); err != nil {
+		return gvs, errors.New("Error reading socket allowed UIDs: " + err.Error())
+	}
+	if len(gvs.SocketAllowedUIDs) == 0 {
+		gvs.SocketAllowedUIDs = []int{os.Getuid()}
+	}
+	if gvs.SocketAllowedPIDs, err = parseIDList(os.Getenv(envSocketAllowedPIDs)); err != nil {
+		return gvs, errors.New("Error reading socket allowed PIDs: " + err.Error())
+	}
+
+	if len(gvs.TargetsFile) > 0 {
+		if gvs.Targets, err = readTargets(gvs.TargetsFile, gvs.SecretAvailabletime); err != nil {
+			return gvs, errors.New("Error reading targets file: " + err.Error())
+		}
+	}
+
+	if len(gvs.SchemaFile) > 0 {
+		if gvs.Schema, err = readSchema(gvs.SchemaFile); err != nil {
+			return gvs, errors.New("Error reading schema file: " + err.Error())
+		}
+	}
+
+	if len(gvs.TokenWrapTTL) > 0 {
+		if len(gvs.TokenFilePath) == 0 {
+			return gvs, errors.New("Token wrap TTL requires a token file path")
+		}
+		if _, err := time.ParseDuration(gvs.TokenWrapTTL); err != nil {
+			return gvs, errors.New("Invalid token wrap TTL " + gvs.TokenWrapTTL + ", expecting a duration such as 60s")
+		}
+	}
+
+	auth, err := gvs.authenticator()
 	if err != nil {
-		return gvs, errors.New("Error reading secret_id secret: " + err.Error())
+		return gvs, errors.New("Error reading auth config: " + err.Error())
+	}
+	if gvs.HTTPCli, err = gvs.httpClient(); err != nil {
+		return gvs, errors.New("Error reading TLS config: " + err.Error())
 	}
 
 	gvs.VaultConfig = vault.NewConfig()
 	gvs.VaultConfig.Address = gvs.VaultURL
-	gvs.VaultConfig.AppRoleCredentials = &vault.AppRoleCredentials{
-		RoleID:   vaultRoleID,
-		SecretID: vaultSecretID,
+	if gvs.VaultConfig.Token, err = auth.login(gvs.HTTPCli, gvs.VaultURL); err != nil {
+		return gvs, errors.New("Error logging in Vault with " + gvs.AuthMethod + " auth: " + err.Error())
 	}
 
-	gvs.VaultCli, err = vault.NewClient(gvs.VaultConfig)
-	if err != nil {
-		return gvs, errors.New("Error creating new Vault client: " + err.Error())
+	if err := gvs.useLoginToken(gvs.VaultConfig.Token); err != nil {
+		return gvs, errors.New("Error looking up Vault token: " + err.Error())
+	}
+	if info := gvs.tokenInfo(); info.Renewable && info.TTL > 0 {
+		go gvs.renewToken()
 	}
 
 	log.Debugf("gvs config: %+v", gvs.AppName)
@@ -142,76 +380,158 @@ func newGVS() (*gvs, error) {
 }
 
 func main() {
-	if err := publishVaultSecret(); err != nil {
-		log.Fatalf("Error publishing secret: %v", err)
+	mode, cmdArgs, err := parseArgs(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Error parsing arguments: %v", err)
+	}
+	switch mode {
+	case modeExec:
+		if err := execVaultSecret(cmdArgs); err != nil {
+			log.Fatalf("Error executing %v: %v", cmdArgs[0], err)
+		}
+	case modeDaemon:
+		if err := daemonVaultSecret(); err != nil {
+			log.Fatalf("Error refreshing secret: %v", err)
+		}
+	case modeSupervise:
+		exitCode, err := superviseVaultSecret(cmdArgs)
+		if err != nil {
+			log.Fatalf("Error supervising %v: %v", cmdArgs[0], err)
+		}
+		os.Exit(exitCode)
+	default:
+		if err := publishVaultSecret(); err != nil {
+			log.Fatalf("Error publishing secret: %v", err)
+		}
 	}
 }
 
-// getVaultSecret read secret kv at given path
-// Returns a key value list
+// getVaultSecret read secret kv at given path, pinned to a version
+// with the path@version syntax (kv v2 only)
+// Returns a key value list, nested values being flattened
 func (g *gvs) getVaultSecret(path string) (kv map[string]string, err error) {
-	kvMap, err := g.VaultCli.GetSecret(path)
+	path, version, err := splitSecretVersion(path)
 	if err != nil {
 		return kv, err
 	}
-
-	return kvMap.KV, nil
-}
-
-func publishVaultSecret() error {
-	g, err := newGVS()
+	secret, _, err := g.readVaultSecret(path, version)
 	if err != nil {
-		return errors.Wrap(errors.WithStack(err), errInfo())
-	}
-	//Checking if secret file is writeable and deleteable
-	secretFileOK, errSecretFile := g.isSecretFilePathOK()
-	if errSecretFile != nil {
-		return errors.WithStack(errSecretFile)
+		return kv, err
 	}
-	if secretFileOK {
-		secretsList := make(map[string]string)
-		kvMap, err := g.getVaultSecret(g.VaultSecretPath)
+	return flattenSecrets(secret, g.Flatten), nil
+}
+
+// getSecretsList reads the application secrets from Vault, merging the
+// secret paths in order, and adds the GVS_APPNAME & GVS_APPENV values.
+// The secrets of a folder path are all read, their keys being prefixed
+// with their path in the folder.
+func (g *gvs) getSecretsList() (map[string]interface{}, error) {
+	secretsList := make(map[string]interface{})
+	sources := make(map[string]string)
+	var metadata []secretMetadata
+	read := make(map[string]bool)
+	for _, entry := range g.VaultSecretPaths {
+		// the secrets listed in a folder are read as is, even with an @ in
+		// their name
+		path, version, err := splitSecretVersion(entry)
 		if err != nil {
-			return errors.Wrap(errors.WithStack(err), errInfo())
+			return secretsList, errors.WithStack(err)
 		}
-		for k, v := range kvMap {
-			secretsList[k] = v
+		paths := []string{path}
+		if isSecretFolder(path) {
+			if paths, err = g.listSecrets(path); err != nil {
+				return secretsList, errors.Wrap(errors.WithStack(err), errInfo())
+			}
 		}
+		for _, secretPath := range paths {
+			kvMap, meta, err := g.readVaultSecret(secretPath, version)
+			if err != nil {
+				return secretsList, errors.Wrap(errors.WithStack(err), errInfo())
+			}
+			metadata = append(metadata, meta)
+			read[meta.Path] = true
+			kvMap, mapped, err := mapKeys(g.KeyMap, meta.Path, kvMap)
+			if err != nil {
+				return secretsList, errors.WithStack(err)
+			}
+			if isSecretFolder(path) && !mapped {
+				kvMap = prefixKeys(kvMap, folderKeyPrefix(path, secretPath))
+			}
+			kvMap = g.formatKeys(kvMap)
+			if err := mergeSecrets(secretsList, sources, kvMap, secretPath, g.SecretConflict); err != nil {
+				return secretsList, errors.WithStack(err)
+			}
+		}
+	}
 
-		// add GVS_APPNAME & GVS_APPENV to secretfile
-		secretsList["GVS_APPNAME"] = g.AppName
-		secretsList["GVS_APPENV"] = g.AppEnv
-
-		for kd, vd := range secretsList {
-			log.Debugf("Populated secret: %v = %v (value hidden)", kd, generateRandomString(len(vd)))
+	if err := checkKeyMap(g.KeyMap, read); err != nil {
+		return secretsList, errors.WithStack(err)
+	}
+	if err := decodeSecrets(secretsList, g.Base64Keys, g.Base64Suffix); err != nil {
+		return secretsList, errors.WithStack(err)
+	}
+	if g.Schema != nil {
+		if err := g.Schema.validate(secretsList); err != nil {
+			return secretsList, errors.WithStack(err)
 		}
-		// create the secret file
-		err = g.writeSecret(secretsList)
-		if err != nil {
-			return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+
+	// add GVS_APPNAME & GVS_APPENV to secret list
+	secretsList["GVS_APPNAME"] = g.AppName
+	secretsList["GVS_APPENV"] = g.AppEnv
+	sources["GVS_APPNAME"], sources["GVS_APPENV"] = "gvs config", "gvs config"
+
+	g.SecretsMetadata = metadata
+	switch g.SecretMetadata {
+	case metadataInline:
+		output, _ := json.Marshal(metadata)
+		secretsList[metadataKey] = string(output)
+		sources[metadataKey] = "gvs config"
+	case metadataFile:
+		if err := g.writeMetadata(); err != nil {
+			return secretsList, errors.Wrap(errors.WithStack(err), errInfo())
 		}
-		_ = destroySecretFile(g.SecretFilePath, g.SecretAvailabletime)
 	}
-	log.Infof("Secret file: %v, will be removed in %v seconds", g.SecretFilePath, g.SecretAvailabletime)
-	return nil
+
+	for kd, vd := range secretsList {
+		log.Debugf("Populated secret: %v = %v (value hidden) from %v", kd, generateRandomString(len(stringValue(vd))), sources[kd])
+	}
+	return secretsList, nil
 }
 
-func (g *gvs) writeSecret(kv map[string]string) error {
-	f, err := os.Create(g.SecretFilePath)
+func publishVaultSecret() (err error) {
+	g, err := newGVS()
 	if err != nil {
 		return errors.Wrap(errors.WithStack(err), errInfo())
 	}
-	defer f.Close()
-	if g.OutputFormat == "yaml" {
-		output, _ := yaml.Marshal(&kv)
-		_, _ = f.Write(output)
-	} else {
-		for k, v := range kv {
-			_, _ = f.WriteString(k + "=" + v + "\n")
-		}
+	defer func() { g.revokeToken(err != nil) }()
+	stopped, stopSignalWatch := watchStopSignals()
+	defer stopSignalWatch()
+	targets := g.targets(modePublish)
+	//Checking if secret targets are writeable and deleteable
+	if err := checkTargets(targets, modePublish); err != nil {
+		return errors.WithStack(err)
+	}
+	secretsList, err := g.getSecretsList()
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if isStopped(stopped) {
+		return errStopped
+	}
+	setStopped(targets, stopped)
+	if err := publishTargets(targets, secretsList); err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
 	}
-	f.Sync()
-	return err
+	if len(g.TokenFilePath) > 0 {
+		return errors.Wrap(g.writeTokenFile(true), errInfo())
+	}
+	return nil
+}
+
+// writeSecret writes the secret file of the target defined by the GVS_ variables
+func (g *gvs) writeSecret(kv map[string]string) error {
+	return g.defaultTarget().write(secretsFromStrings(kv))
 }
 
 func getSecretFromFile(path string) (secret string, err error) {
@@ -223,49 +543,81 @@ func getSecretFromFile(path string) (secret string, err error) {
 	return string(dat), nil
 }
 
+// isSecretFilePathOK checks the target defined by the GVS_ variables is writeable and deleteable
 func (g *gvs) isSecretFilePathOK() (isOK bool, err error) {
-	testFile := g.SecretFilePath + ".tmp"
-	// create tmp test file
-	f, err := os.Create(testFile)
-	if err != nil {
-		return false, errors.Wrap(err, errInfo())
-	}
-	defer f.Close()
+	return g.defaultTarget().isPathOK()
+}
 
-	_, err = f.WriteString("test\n")
-	if err != nil {
-		return false, errors.Wrap(err, errInfo())
+// errStopped is returned when gvs is stopped by SIGTERM or SIGINT before the
+// end of the run
+var errStopped = errors.New("gvs stopped before the end of the run")
+
+// watchStopSignals catches SIGTERM and SIGINT until stop is called, which can
+// be done several times. The stopped channel is closed on signal, so that the
+// publishing is cancelled and the run returns through its deferred cleanup
+// (sockets and fifos removal, leases and token revocation).
+func watchStopSignals() (stopped <-chan struct{}, stop func()) {
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	cancel := make(chan struct{})
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		select {
+		case sig := <-sigs:
+			log.Infof("Received %v, stopping", sig)
+			close(cancel)
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return cancel, func() {
+		once.Do(func() {
+			signal.Stop(sigs)
+			close(done)
+		})
 	}
+}
 
-	f.Sync()
-
-	// remove tmp test file
-	err = destroySecretFile(testFile, "0")
-	if err != nil {
-		return false, errors.Wrap(err, errInfo())
+// isStopped returns true when the stopped channel is closed
+func isStopped(stopped <-chan struct{}) bool {
+	select {
+	case <-stopped:
+		return true
+	default:
+		return false
 	}
-	return true, nil
+}
 
+// setStopped makes the targets publishing cancelled when stopped is closed
+func setStopped(targets []*secretTarget, stopped <-chan struct{}) {
+	for _, t := range targets {
+		t.stopped = stopped
+	}
 }
 
+// destroySecretFile securely deletes path after delay seconds.
+// Deletion is immediate when delay is 0, otherwise it is handled
+// by a detached gvs reaper process.
 func destroySecretFile(path, delay string) error {
-	rmPath, err := exec.LookPath("rm")
+	numSec, err := strconv.Atoi(delay)
 	if err != nil {
 		return errors.Wrap(err, errInfo())
 	}
-
-	sleepPath, err := exec.LookPath("sleep")
-	if err != nil {
-		return errors.Wrap(err, errInfo())
+	if numSec <= 0 {
+		return errors.Wrap(secureDelete(path), errInfo())
 	}
+	return errors.Wrap(startReaper(path, delay, nil), errInfo())
+}
 
-	cmdUmount := exec.Command("/bin/sh", "-c", sleepPath+" "+delay+" && "+rmPath+" "+path)
-	err = cmdUmount.Start()
+// destroySecretFileOnRead securely deletes path as soon as it has been read,
+// or after delay seconds at the latest
+func destroySecretFileOnRead(path, delay string) error {
+	watcher, err := newReadWatcher(path)
 	if err != nil {
 		return errors.Wrap(err, errInfo())
 	}
-
-	return nil
+	defer watcher.Close()
+	return errors.Wrap(startReaper(path, delay, watcher), errInfo())
 }
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
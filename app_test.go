@@ -328,6 +328,24 @@ func Test_newGVS(t *testing.T) {
 			envVaultSecretID:       "",
 		},
 			true},
+		{"wrongOutputFormat", &gvs{AppName: "my-app",
+			AppEnv:              "dev",
+			VaultURL:            vCfg.Address,
+			VaultSecretPath:     "kv_v2/my-app-dev",
+			VaultRoleID:         "/tmp/role_id",
+			VaultSecretID:       "/tmp/secret_id",
+			SecretFilePath:      "/dev/shm/gvs",
+			SecretAvailabletime: "60",
+			VaultToken:          "",
+			OutputFormat:        "xml",
+			LogLevel:            "INFO",
+			VaultConfig:         nil,
+			VaultCli:            nil,
+		}, map[string]string{
+			"GVS_SECRETAVAILABLETIME": "60",
+			envOutputFormat:           "xml",
+		},
+			true},
 		{"wrongVaultURL", &gvs{AppName: "my-app",
 			AppEnv:              "dev",
 			VaultURL:            "ht@ps/:/wronghost",
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Vault auth methods
+const authToken = "token"
+const authAppRole = "approle"
+const authKubernetes = "kubernetes"
+const authJWT = "jwt"
+const authCert = "cert"
+
+// projected service account token of the pod
+const defaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// timeout of the Vault requests
+const vaultTimeout = 30 * time.Second
+
+// authenticator logs in Vault, returning the client token
+type authenticator interface {
+	login(cli *http.Client, address string) (string, error)
+}
+
+// tokenAuth uses a token obtained beforehand, from VAULT_TOKEN or a file
+type tokenAuth struct {
+	token     string
+	tokenPath string
+}
+
+// appRoleAuth logs in with the role_id and secret_id read from files,
+// the secret_id file holding a response wrapping token when wrapped
+type appRoleAuth struct {
+	mount        string
+	roleIDPath   string
+	secretIDPath string
+	wrapped      bool
+}
+
+// kubernetesAuth logs in with the pod service account token
+type kubernetesAuth struct {
+	mount     string
+	role      string
+	tokenPath string
+}
+
+// jwtAuth logs in with a JWT/OIDC token read from a file
+type jwtAuth struct {
+	mount   string
+	role    string
+	jwtPath string
+}
+
+// certAuth logs in with the TLS client certificate, role being optional
+type certAuth struct {
+	mount string
+	role  string
+}
+
+// authenticator returns the authenticator of the configured auth method,
+// setting the default values
+func (g *gvs) authenticator() (authenticator, error) {
+	switch g.AuthMethod {
+	case "":
+		g.AuthMethod = authAppRole
+	case authAppRole, authToken, authKubernetes, authJWT, authCert:
+	default:
+		return nil, errors.New("unsupported auth method " + g.AuthMethod)
+	}
+	if len(g.AuthMount) == 0 {
+		g.AuthMount = g.AuthMethod
+	}
+	switch g.AuthMethod {
+	case authToken:
+		if len(g.VaultToken) == 0 && len(g.VaultTokenPath) == 0 {
+			return nil, errors.New("no Vault token provided")
+		}
+		return tokenAuth{g.VaultToken, g.VaultTokenPath}, nil
+	case authKubernetes:
+		if len(g.AuthRole) == 0 {
+			return nil, errors.New("no Vault role provided for kubernetes auth")
+		}
+		if len(g.K8sTokenPath) == 0 {
+			g.K8sTokenPath = defaultKubernetesTokenPath
+		}
+		return kubernetesAuth{g.AuthMount, g.AuthRole, g.K8sTokenPath}, nil
+	case authJWT:
+		if len(g.AuthRole) == 0 || len(g.JWTPath) == 0 {
+			return nil, errors.New("Vault role and JWT path are required for jwt auth")
+		}
+		return jwtAuth{g.AuthMount, g.AuthRole, g.JWTPath}, nil
+	case authCert:
+		if len(g.TLSCert) == 0 || len(g.TLSKey) == 0 {
+			return nil, errors.New("TLS client certificate and key are required for cert auth")
+		}
+		return certAuth{g.AuthMount, g.AuthRole}, nil
+	}
+	return appRoleAuth{g.AuthMount, g.VaultRoleID, g.VaultSecretID, g.SecretIDWrapped}, nil
+}
+
+// httpClient returns the http client of the Vault requests, trusting the
+// CACert authority and presenting the TLS client certificate, if any
+func (g *gvs) httpClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if len(g.CACert) > 0 {
+		ca, err := ioutil.ReadFile(g.CACert)
+		if err != nil {
+			return nil, errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no certificate found in " + g.CACert)
+		}
+	}
+	if len(g.TLSCert) > 0 {
+		cert, err := tls.LoadX509KeyPair(g.TLSCert, g.TLSKey)
+		if err != nil {
+			return nil, errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Timeout: vaultTimeout, Transport: transport}, nil
+}
+
+// vaultCall calls the Vault API path with the given headers (ie X-Vault-Token)
+// and json payload, if any, and unmarshals the json response, if any, in result
+func vaultCall(cli *http.Client, method, address, path string, header map[string]string, payload, result interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		body = bytes.NewReader(data)
+	}
+	url := strings.TrimSuffix(address, "/") + path
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rsp, err := cli.Do(req)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode == http.StatusNoContent || (rsp.StatusCode == http.StatusOK && result == nil) {
+		return nil
+	}
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return &vaultCallError{url, rsp.StatusCode, rsp.Status, string(data)}
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	return nil
+}
+
+// vaultCallError is returned when Vault replies an error status
+type vaultCallError struct {
+	url        string
+	statusCode int
+	status     string
+	body       string
+}
+
+func (e *vaultCallError) Error() string {
+	return "Vault call " + e.url + " returned " + e.status + ": " + e.body
+}
+
+// isWrappingTokenRejected returns true when the error is Vault rejecting
+// the wrapping token, as opposed to transport or server errors
+func isWrappingTokenRejected(err error) bool {
+	e, ok := errors.Cause(err).(*vaultCallError)
+	return ok && e.statusCode == http.StatusBadRequest && strings.Contains(e.body, "wrapping token is not valid")
+}
+
+// vaultLogin logs in Vault with the auth method mounted at mount.
+// Returns the client token.
+func vaultLogin(cli *http.Client, address, mount string, payload interface{}) (string, error) {
+	var login struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	path := "/v1/auth/" + strings.Trim(mount, "/") + "/login"
+	if err := vaultCall(cli, "POST", address, path, nil, payload, &login); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if len(login.Auth.ClientToken) == 0 {
+		return "", errors.New("Vault login " + path + " returned no token")
+	}
+	return login.Auth.ClientToken, nil
+}
+
+// unwrapSecretID unwraps the AppRole secret_id wrapped in wrapToken, after
+// having checked the token was created by the secret-id endpoint of a role
+// of the AppRole mount. A token rejected by Vault (invalid, expired or
+// already unwrapped) or created by another endpoint is reported as a
+// security alert.
+func unwrapSecretID(cli *http.Client, address, mount, wrapToken string) (string, error) {
+	var lookup struct {
+		Data struct {
+			CreationPath string `json:"creation_path"`
+		} `json:"data"`
+	}
+	if err := vaultCall(cli, "POST", address, "/v1/sys/wrapping/lookup", nil, map[string]string{"token": wrapToken}, &lookup); err != nil {
+		if !isWrappingTokenRejected(err) {
+			return "", errors.Wrap(err, "error looking up secret_id wrapping token")
+		}
+		log.WithField("alert", "security").Errorf("SECURITY ALERT: secret_id wrapping token is invalid, expired or was already unwrapped, it may have been intercepted: %v", err)
+		return "", errors.Wrap(err, "invalid secret_id wrapping token")
+	}
+	expected := regexp.MustCompile("^auth/" + regexp.QuoteMeta(strings.Trim(mount, "/")) + "/role/[^/]+/secret-id$")
+	if !expected.MatchString(lookup.Data.CreationPath) {
+		log.WithField("alert", "security").Errorf("SECURITY ALERT: secret_id wrapping token was created by %v, not by the %v AppRole secret-id endpoint", lookup.Data.CreationPath, mount)
+		return "", errors.New("unexpected secret_id wrapping token creation path " + lookup.Data.CreationPath)
+	}
+	var unwrap struct {
+		Data struct {
+			SecretID string `json:"secret_id"`
+		} `json:"data"`
+	}
+	if err := vaultCall(cli, "POST", address, "/v1/sys/wrapping/unwrap", map[string]string{"X-Vault-Token": wrapToken}, nil, &unwrap); err != nil {
+		if !isWrappingTokenRejected(err) {
+			return "", errors.Wrap(err, "error unwrapping secret_id")
+		}
+		log.WithField("alert", "security").Errorf("SECURITY ALERT: secret_id wrapping token could not be unwrapped, it may have been used by someone else: %v", err)
+		return "", errors.Wrap(err, "error unwrapping secret_id")
+	}
+	if len(unwrap.Data.SecretID) == 0 {
+		return "", errors.New("no secret_id in the wrapping token")
+	}
+	return unwrap.Data.SecretID, nil
+}
+
+// readCredential reads a credential file, trimming the trailing new line
+func readCredential(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (a tokenAuth) login(cli *http.Client, address string) (string, error) {
+	if len(a.token) > 0 {
+		return a.token, nil
+	}
+	return readCredential(a.tokenPath)
+}
+
+func (a appRoleAuth) login(cli *http.Client, address string) (string, error) {
+	roleID, err := getSecretFromFile(a.roleIDPath)
+	if err != nil {
+		return "", errors.New("Error reading role_id secret: " + err.Error())
+	}
+	secretID, err := getSecretFromFile(a.secretIDPath)
+	if err != nil {
+		return "", errors.New("Error reading secret_id secret: " + err.Error())
+	}
+	if a.wrapped {
+		if secretID, err = unwrapSecretID(cli, address, a.mount, strings.TrimSpace(secretID)); err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+	return vaultLogin(cli, address, a.mount, map[string]string{
+		"role_id":   roleID,
+		"secret_id": secretID,
+	})
+}
+
+func (a kubernetesAuth) login(cli *http.Client, address string) (string, error) {
+	jwt, err := readCredential(a.tokenPath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return vaultLogin(cli, address, a.mount, map[string]string{"role": a.role, "jwt": jwt})
+}
+
+func (a jwtAuth) login(cli *http.Client, address string) (string, error) {
+	jwt, err := readCredential(a.jwtPath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return vaultLogin(cli, address, a.mount, map[string]string{"role": a.role, "jwt": jwt})
+}
+
+func (a certAuth) login(cli *http.Client, address string) (string, error) {
+	payload := map[string]string{}
+	if len(a.role) > 0 {
+		payload["name"] = a.role
+	}
+	return vaultLogin(cli, address, a.mount, payload)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// writeTestCert writes a self-signed client certificate and its key
+func writeTestCert(t *testing.T, certPath, keyPath string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "my-app"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating test certificate: %v", err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	_ = ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+}
+
+func Test_gvs_authenticator(t *testing.T) {
+	tests := []struct {
+		name    string
+		g       *gvs
+		want    authenticator
+		wantErr bool
+	}{
+		{"default", &gvs{VaultRoleID: "/run/secrets/role_id", VaultSecretID: "/run/secrets/secret_id"},
+			appRoleAuth{"approle", "/run/secrets/role_id", "/run/secrets/secret_id", false}, false},
+		{"wrapped", &gvs{VaultRoleID: "/run/secrets/role_id", VaultSecretID: "/run/secrets/secret_id", SecretIDWrapped: true},
+			appRoleAuth{"approle", "/run/secrets/role_id", "/run/secrets/secret_id", true}, false},
+		{"token", &gvs{AuthMethod: authToken, VaultToken: "s.token"}, tokenAuth{"s.token", ""}, false},
+		{"noToken", &gvs{AuthMethod: authToken}, nil, true},
+		{"kubernetes", &gvs{AuthMethod: authKubernetes, AuthRole: "my-app"},
+			kubernetesAuth{"kubernetes", "my-app", defaultKubernetesTokenPath}, false},
+		{"kubernetesNoRole", &gvs{AuthMethod: authKubernetes}, nil, true},
+		{"jwt", &gvs{AuthMethod: authJWT, AuthMount: "oidc", AuthRole: "my-app", JWTPath: "/run/secrets/jwt"},
+			jwtAuth{"oidc", "my-app", "/run/secrets/jwt"}, false},
+		{"jwtNoPath", &gvs{AuthMethod: authJWT, AuthRole: "my-app"}, nil, true},
+		{"cert", &gvs{AuthMethod: authCert, TLSCert: "cert.pem", TLSKey: "key.pem"}, certAuth{"cert", ""}, false},
+		{"certNoKey", &gvs{AuthMethod: authCert, TLSCert: "cert.pem"}, nil, true},
+		{"unknown", &gvs{AuthMethod: "ldap"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.g.authenticator()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("gvs.authenticator() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("gvs.authenticator() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_authenticator_login(t *testing.T) {
+	v := newFakeVault()
+	defer v.Close()
+	v.logins["approle/prod"] = map[string]string{"role_id": "role", "secret_id": "secret"}
+	v.logins["kubernetes"] = map[string]string{"role": "my-app", "jwt": "sa-jwt"}
+	v.logins["jwt"] = map[string]string{"role": "my-app", "jwt": "ci-jwt"}
+	files := map[string]string{
+		"./test.role_id":   "role",
+		"./test.secret_id": "secret",
+		"./test.satoken":   "sa-jwt\n",
+		"./test.jwt":       "ci-jwt\n",
+		"./test.token":     fakeVaultToken + "\n",
+	}
+	for path, content := range files {
+		_ = ioutil.WriteFile(path, []byte(content), 0600)
+		defer os.Remove(path)
+	}
+	tests := []struct {
+		name    string
+		auth    authenticator
+		wantErr bool
+	}{
+		{"token", tokenAuth{token: fakeVaultToken}, false},
+		{"tokenFile", tokenAuth{tokenPath: "./test.token"}, false},
+		{"approle", appRoleAuth{"approle/prod", "./test.role_id", "./test.secret_id", false}, false},
+		{"approleWrongMount", appRoleAuth{"approle", "./test.role_id", "./test.secret_id", false}, true},
+		{"approleNoSecretID", appRoleAuth{"approle/prod", "./test.role_id", "./test.nofile", false}, true},
+		{"kubernetes", kubernetesAuth{"kubernetes", "my-app", "./test.satoken"}, false},
+		{"kubernetesWrongRole", kubernetesAuth{"kubernetes", "other-app", "./test.satoken"}, true},
+		{"jwt", jwtAuth{"/jwt/", "my-app", "./test.jwt"}, false},
+		{"jwtWrongToken", jwtAuth{"jwt", "my-app", "./test.satoken"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.auth.login(http.DefaultClient, v.URL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("authenticator.login() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got != fakeVaultToken {
+				t.Errorf("authenticator.login() = %v, want %v", got, fakeVaultToken)
+			}
+		})
+	}
+}
+
+func Test_appRoleAuth_login_wrapped(t *testing.T) {
+	v := newFakeVault()
+	defer v.Close()
+	v.logins["approle/prod"] = map[string]string{"role_id": "role", "secret_id": "secret"}
+	v.wrapped["s.wrapped"] = fakeWrapped{"auth/approle/prod/role/my-app/secret-id",
+		map[string]interface{}{"secret_id": "secret", "secret_id_accessor": "accessor"}}
+	v.wrapped["s.other"] = fakeWrapped{"auth/approle/prod/role/my-app/role-id",
+		map[string]interface{}{"role_id": "role"}}
+	files := map[string]string{
+		"./test.role_id":      "role",
+		"./test.wrapped":      "s.wrapped\n",
+		"./test.otherwrapped": "s.other",
+		"./test.secret_id":    "secret",
+	}
+	for path, content := range files {
+		_ = ioutil.WriteFile(path, []byte(content), 0600)
+		defer os.Remove(path)
+	}
+	tests := []struct {
+		name         string
+		secretIDPath string
+		wantErr      bool
+	}{
+		{"ok", "./test.wrapped", false},
+		{"alreadyUnwrapped", "./test.wrapped", true},
+		{"notWrapped", "./test.secret_id", true},
+		{"wrongCreationPath", "./test.otherwrapped", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := appRoleAuth{"approle/prod", "./test.role_id", tt.secretIDPath, true}.login(http.DefaultClient, v.URL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("appRoleAuth.login() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got != fakeVaultToken {
+				t.Errorf("appRoleAuth.login() = %v, want %v", got, fakeVaultToken)
+			}
+		})
+	}
+	if _, ok := v.wrapped["s.other"]; !ok {
+		t.Errorf("wrapping token with a wrong creation path was unwrapped")
+	}
+}
+
+func Test_isWrappingTokenRejected(t *testing.T) {
+	rejected := &vaultCallError{"/v1/sys/wrapping/lookup", http.StatusBadRequest, "400 Bad Request",
+		`{"errors":["wrapping token is not valid or does not exist"]}`}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rejected", rejected, true},
+		{"wrapped", errors.Wrap(rejected, "error"), true},
+		{"serverError", &vaultCallError{"/v1/sys/wrapping/lookup", http.StatusInternalServerError, "500 Internal Server Error", `{"errors":["internal error"]}`}, false},
+		{"sealed", &vaultCallError{"/v1/sys/wrapping/lookup", http.StatusServiceUnavailable, "503 Service Unavailable", `{"errors":["Vault is sealed"]}`}, false},
+		{"connection", errors.New("dial tcp 127.0.0.1:8200: connect: connection refused"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isWrappingTokenRejected(tt.err); got != tt.want {
+				t.Errorf("isWrappingTokenRejected() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_certAuth_login(t *testing.T) {
+	v := newFakeVaultTLS()
+	defer v.Close()
+	v.logins["cert"] = map[string]string{"name": "my-app"}
+	caPath, certPath, keyPath := "./test.ca.pem", "./test.cert.pem", "./test.key.pem"
+	_ = ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: v.Certificate().Raw}), 0600)
+	writeTestCert(t, certPath, keyPath)
+	defer os.Remove(caPath)
+	defer os.Remove(certPath)
+	defer os.Remove(keyPath)
+	tests := []struct {
+		name    string
+		g       *gvs
+		wantErr bool
+	}{
+		{"ok", &gvs{CACert: caPath, TLSCert: certPath, TLSKey: keyPath}, false},
+		{"noClientCert", &gvs{CACert: caPath}, true},
+		{"untrustedVault", &gvs{TLSCert: certPath, TLSKey: keyPath}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli, err := tt.g.httpClient()
+			if err != nil {
+				t.Fatalf("gvs.httpClient() error = %v", err)
+			}
+			got, err := certAuth{"cert", "my-app"}.login(cli, v.URL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("certAuth.login() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got != fakeVaultToken {
+				t.Errorf("certAuth.login() = %v, want %v", got, fakeVaultToken)
+			}
+		})
+	}
+}
+
+func Test_newGVS_tls(t *testing.T) {
+	v := newFakeVaultTLS()
+	defer v.Close()
+	v.kv2["my-app-dev"] = []map[string]interface{}{{"password": "s3cr3t"}}
+	caPath := "./test.ca.pem"
+	_ = ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: v.Certificate().Raw}), 0600)
+	defer os.Remove(caPath)
+	env := map[string]string{
+		envVaultAddr:   v.URL,
+		envAuthMethod:  authToken,
+		envVaultToken:  fakeVaultToken,
+		envCACert:      caPath,
+		envSecretPath:  "kv_v2/my-app-dev",
+		envAppName:     "my-app",
+		envAppEnv:      "dev",
+		envTargetsFile: "",
+	}
+	for k, value := range env {
+		if old, ok := os.LookupEnv(k); ok {
+			defer os.Setenv(k, old)
+		} else {
+			defer os.Unsetenv(k)
+		}
+		os.Setenv(k, value)
+	}
+	g, err := newGVS()
+	if err != nil {
+		t.Fatalf("newGVS() error = %v", err)
+	}
+	secrets, err := g.getSecretsList()
+	if err != nil {
+		t.Fatalf("gvs.getSecretsList() error = %v", err)
+	}
+	if secrets["password"] != "s3cr3t" {
+		t.Errorf("gvs.getSecretsList() password = %v, want s3cr3t", secrets["password"])
+	}
+
+	os.Unsetenv(envCACert)
+	if _, err := newGVS(); err == nil {
+		t.Errorf("newGVS() with an untrusted Vault, want error")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	vault "github.com/mch1307/vaultlib"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const modeDaemon = "daemon"
+
+// how often the Vault client token is checked for renewal
+const tokenWatchInterval = 5 * time.Second
+
+// daemonVaultSecret writes the secret file(s) and keeps them up to date until
+// gvs receives SIGTERM or SIGINT, the files being removed and the Vault
+// token and leases revoked at shutdown.
+// Secrets are read again every RefreshInterval seconds and each time the
+// Vault token is renewed.
+func daemonVaultSecret() error {
+	g, err := newGVS()
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	defer g.revokeToken(true)
+	targets := g.targets(modeDaemon)
+	if err := checkTargets(targets, modeDaemon); err != nil {
+		return errors.WithStack(err)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigs)
+
+	if err := g.refreshSecret(targets); err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	for _, t := range targets {
+		defer t.remove()
+		log.Infof("Secret file: %v, will be kept up to date until shutdown", t.Path)
+	}
+	if len(g.TokenFilePath) > 0 {
+		if err := g.writeTokenFile(false); err != nil {
+			return errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		defer g.removeTokenFile()
+	}
+
+	interval, _ := strconv.Atoi(g.RefreshInterval)
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	tokenTicker := time.NewTicker(tokenWatchInterval)
+	defer tokenTicker.Stop()
+	token := g.tokenInfo()
+
+	for {
+		select {
+		case sig := <-sigs:
+			log.Infof("Received %v, stopping", sig)
+			return nil
+		case <-ticker.C:
+		case <-tokenTicker.C:
+			if !g.tokenRenewed(&token) {
+				continue
+			}
+			log.Debugf("Vault token renewed")
+		}
+		if err := g.refreshSecret(targets); err != nil {
+			log.Errorf("Error refreshing secret files, keeping current ones: %v", err)
+		}
+	}
+}
+
+// refreshSecret reads the secrets and replaces the target files which changed.
+// The leases of the superseded dynamic secrets are revoked once their grace
+// period is over.
+func (g *gvs) refreshSecret(targets []*secretTarget) error {
+	g.revokeStaleLeases()
+	secretsList, err := g.getSecretsList()
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	return errors.Wrap(replaceTargets(targets, secretsList), errInfo())
+}
+
+// replaceTargets replaces the content of the target files which changed
+func replaceTargets(targets []*secretTarget, kv map[string]interface{}) error {
+	for _, t := range targets {
+		changed, err := t.replace(kv)
+		if err != nil {
+			return errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		if changed {
+			log.Infof("Secret file %v updated", t.Path)
+		}
+	}
+	return nil
+}
+
+// tokenRenewed returns true when the token info changed since last call.
+// The token info is replaced each time the token is renewed.
+func (g *gvs) tokenRenewed(last **vault.VaultTokenInfo) bool {
+	current := g.tokenInfo()
+	if current == *last {
+		return false
+	}
+	*last = current
+	return true
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_gvs_refreshSecret(t *testing.T) {
+	v := newFakeVault()
+	defer v.Close()
+	tests := []struct {
+		name        string
+		nearExpiry  bool
+		graceOver   bool
+		wantRevoked []string
+		wantLeases  []string
+		wantUser    string
+	}{
+		{"reused", false, false, nil, []string{"database/creds/my-app/1"}, "v-app-1"},
+		{"nearExpiry", true, false, nil, []string{"database/creds/my-app/1", "database/creds/my-app/2"}, "v-app-2"},
+		{"graceOver", true, true, []string{"database/creds/my-app/1"}, []string{"database/creds/my-app/2"}, "v-app-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v.revoked, v.leases = nil, 0
+			g := v.gvs(t)
+			g.AuthMethod = authAppRole
+			g.VaultSecretPaths = []string{"database/creds/my-app"}
+			target := &secretTarget{Type: targetFile, Path: "./test.refresh", Format: formatKV}
+			defer os.Remove(target.Path)
+			if err := g.refreshSecret([]*secretTarget{target}); err != nil {
+				t.Fatalf("gvs.refreshSecret() error = %v", err)
+			}
+			if tt.nearExpiry {
+				g.dynamicSecrets["database/creds/my-app"].renewAt = time.Now()
+			}
+			if err := g.refreshSecret([]*secretTarget{target}); err != nil {
+				t.Fatalf("gvs.refreshSecret() error = %v", err)
+			}
+			if tt.graceOver {
+				g.staleLeases[0].revokeAt = time.Now()
+				if err := g.refreshSecret([]*secretTarget{target}); err != nil {
+					t.Fatalf("gvs.refreshSecret() error = %v", err)
+				}
+			}
+			if !reflect.DeepEqual(v.revoked, tt.wantRevoked) {
+				t.Errorf("gvs.refreshSecret() revoked %v, want %v", v.revoked, tt.wantRevoked)
+			}
+			if !reflect.DeepEqual(g.Leases, tt.wantLeases) {
+				t.Errorf("gvs.refreshSecret() leases %v, want %v", g.Leases, tt.wantLeases)
+			}
+			data, _ := ioutil.ReadFile(target.Path)
+			if want := "username=" + tt.wantUser + "\n"; !strings.Contains(string(data), want) {
+				t.Errorf("gvs.refreshSecret() content = %q, want %q", data, want)
+			}
+		})
+	}
+}
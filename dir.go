@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// permissions of the secret directory created by the dir output format
+const secretDirMode = 0755
+
+// isKeyFileNameOK returns true when the secret key can be used as a file name
+func isKeyFileNameOK(key string) bool {
+	return len(key) > 0 && key != "." && key != ".." && !strings.ContainsAny(key, "/\x00")
+}
+
+// checkSecretDir checks path can be used as the secret directory: as it is
+// removed with all its files once the secrets expire, it must not exist or
+// be empty
+func checkSecretDir(path string) error {
+	files, err := ioutil.ReadDir(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if len(files) > 0 {
+		return errors.New("secret directory " + path + " is not empty, the dir output format requires a dedicated directory")
+	}
+	return nil
+}
+
+// replaceDir writes each secret as its own file in the target directory
+// (dir output format), nested values being flattened, removing the files
+// it wrote for the secrets which no longer exist.
+// Returns true if at least one file was (re)written or removed.
+func (t *secretTarget) replaceDir(secrets map[string]interface{}) (changed bool, err error) {
+	kv := flattenSecrets(secrets, t.Flatten)
+	for k := range kv {
+		if !isKeyFileNameOK(k) {
+			return false, errors.New("secret key " + k + " can not be used as a file name")
+		}
+	}
+	if t.dirFiles == nil {
+		if err := checkSecretDir(t.Path); err != nil {
+			return false, errors.WithStack(err)
+		}
+		if err := os.MkdirAll(t.Path, secretDirMode); err != nil {
+			return false, errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		t.dirFiles = make(map[string]bool)
+	}
+
+	for name := range t.dirFiles {
+		if _, ok := kv[name]; ok {
+			continue
+		}
+		if err := secureDelete(filepath.Join(t.Path, name)); err != nil {
+			return changed, errors.WithStack(err)
+		}
+		delete(t.dirFiles, name)
+		log.Debugf("Removed secret file %v from %v", name, t.Path)
+		changed = true
+	}
+
+	for _, k := range sortedKeys(kv) {
+		fileChanged, err := t.replaceFile(filepath.Join(t.Path, k), []byte(kv[k]))
+		if err != nil {
+			return changed, errors.WithStack(err)
+		}
+		t.dirFiles[k] = true
+		changed = changed || fileChanged
+	}
+	return changed, nil
+}
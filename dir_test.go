@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_secretTarget_replaceDir(t *testing.T) {
+	path := "./test.dir"
+	defer os.RemoveAll(path)
+	target := &secretTarget{Type: targetFile, Path: path, Format: formatDir}
+	tests := []struct {
+		name        string
+		kv          map[string]string
+		wantChanged bool
+		wantFiles   []string
+		wantErr     bool
+	}{
+		{"create", map[string]string{"user": "admin", "password": "s3cr3t"}, true, []string{"password", "user"}, false},
+		{"unchanged", map[string]string{"user": "admin", "password": "s3cr3t"}, false, []string{"password", "user"}, false},
+		{"removedKey", map[string]string{"password": "s3cr3t"}, true, []string{"password"}, false},
+		{"wrongKey", map[string]string{"../password": "s3cr3t"}, false, []string{"password"}, true},
+	}
+	binary := []byte{0, 1, 2, 255, '\n'}
+	if _, err := target.replaceDir(map[string]interface{}{"keytab": binary}); err != nil {
+		t.Errorf("replaceDir() binary error = %v", err)
+	}
+	if content, _ := ioutil.ReadFile(path + "/keytab"); !reflect.DeepEqual(content, binary) {
+		t.Errorf("replaceDir() binary = %v, want %v", content, binary)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotChanged, err := target.replaceDir(secretsFromStrings(tt.kv))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("replaceDir() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if gotChanged != tt.wantChanged {
+				t.Errorf("replaceDir() changed = %v, want %v", gotChanged, tt.wantChanged)
+			}
+			files, _ := ioutil.ReadDir(path)
+			var gotFiles []string
+			for _, f := range files {
+				gotFiles = append(gotFiles, f.Name())
+			}
+			if !reflect.DeepEqual(gotFiles, tt.wantFiles) {
+				t.Errorf("replaceDir() files = %v, want %v", gotFiles, tt.wantFiles)
+			}
+			if tt.wantErr {
+				return
+			}
+			for k, v := range tt.kv {
+				if content, _ := ioutil.ReadFile(path + "/" + k); string(content) != v {
+					t.Errorf("replaceDir() %v = %q, want %q", k, content, v)
+				}
+			}
+		})
+	}
+}
+
+func Test_secretTarget_replaceDir_existing(t *testing.T) {
+	path := "./test.dir"
+	defer os.RemoveAll(path)
+	_ = os.Mkdir(path, 0755)
+	_ = ioutil.WriteFile(path+"/secret_id", []byte("keep me"), 0600)
+	target := &secretTarget{Type: targetFile, Path: path, Format: formatDir}
+	if err := (fileSink{target}).check(); err == nil {
+		t.Errorf("fileSink.check() of a non empty directory, want error")
+	}
+	if _, err := target.replaceDir(map[string]interface{}{"password": "s3cr3t"}); err == nil {
+		t.Errorf("replaceDir() in a non empty directory, want error")
+	}
+	if content, _ := ioutil.ReadFile(path + "/secret_id"); string(content) != "keep me" {
+		t.Errorf("replaceDir() removed a file it did not write")
+	}
+
+	// files added to the secret directory are left untouched
+	_ = os.Remove(path + "/secret_id")
+	if _, err := target.replaceDir(map[string]interface{}{"password": "s3cr3t"}); err != nil {
+		t.Fatalf("replaceDir() in an empty directory error = %v", err)
+	}
+	_ = ioutil.WriteFile(path+"/other", []byte("keep me"), 0600)
+	if _, err := target.replaceDir(map[string]interface{}{"user": "admin"}); err != nil {
+		t.Fatalf("replaceDir() error = %v", err)
+	}
+	files, _ := ioutil.ReadDir(path)
+	var gotFiles []string
+	for _, f := range files {
+		gotFiles = append(gotFiles, f.Name())
+	}
+	if want := []string{"other", "user"}; !reflect.DeepEqual(gotFiles, want) {
+		t.Errorf("replaceDir() files = %v, want %v", gotFiles, want)
+	}
+}
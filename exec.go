@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const modePublish = "publish"
+const modeExec = "exec"
+
+// parseArgs returns the run mode and the command (with its args) to be
+// launched when applicable
+//
+//	gvs                            publish the secret file
+//	gvs daemon                     keep the secret file up to date until stopped
+//	gvs exec -- cmd [args]         inject the secrets in cmd environment
+//	gvs supervise -- cmd [args]    same as exec, gvs stays as parent and
+//	                               reloads cmd when the secrets change
+func parseArgs(args []string) (mode string, cmdArgs []string, err error) {
+	if len(args) == 0 {
+		return modePublish, nil, nil
+	}
+	switch args[0] {
+	case modeDaemon:
+		if len(args) > 1 {
+			return args[0], nil, errors.New("unexpected argument " + args[1])
+		}
+		return args[0], nil, nil
+	case modeExec, modeSupervise:
+		cmdArgs = args[1:]
+		if len(cmdArgs) > 0 && cmdArgs[0] == "--" {
+			cmdArgs = cmdArgs[1:]
+		}
+		if len(cmdArgs) == 0 {
+			return args[0], nil, errors.New("no command provided, usage: gvs " + args[0] + " -- <cmd> [args]")
+		}
+		return args[0], cmdArgs, nil
+	}
+	return "", nil, errors.New("unknown command " + args[0])
+}
+
+// buildEnv merges the secrets list into the given environment.
+// Secrets override existing variables with the same name.
+func buildEnv(environ []string, kv map[string]string) []string {
+	env := make([]string, 0, len(environ)+len(kv))
+	for _, e := range environ {
+		name := strings.SplitN(e, "=", 2)[0]
+		if _, ok := kv[name]; ok {
+			continue
+		}
+		env = append(env, e)
+	}
+	for _, k := range sortedKeys(kv) {
+		env = append(env, k+"="+kv[k])
+	}
+	return env
+}
+
+// execVaultSecret reads the secrets, publishes them to the file targets and
+// replaces the gvs process with cmdArgs, the secrets being passed as
+// environment variables when an env target is defined (the default).
+// The gvs Vault token is written to the token file and/or passed in
+// the environment when configured, otherwise it is revoked.
+func execVaultSecret(cmdArgs []string) (err error) {
+	g, err := newGVS()
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	defer g.revokeToken(true)
+	stopped, stopSignalWatch := watchStopSignals()
+	defer stopSignalWatch()
+	cmdPath, err := exec.LookPath(cmdArgs[0])
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	targets := g.targets(modeExec)
+	if err := checkTargets(targets, modeExec); err != nil {
+		return errors.WithStack(err)
+	}
+	secretsList, err := g.getSecretsList()
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if hasEnvTarget(targets) {
+		if err := checkBinarySecrets(secretsList, targetEnv); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if isStopped(stopped) {
+		return errStopped
+	}
+	setStopped(targets, stopped)
+	if err := publishTargets(targets, secretsList); err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if len(g.TokenFilePath) > 0 {
+		if err := g.writeTokenFile(true); err != nil {
+			return errors.Wrap(errors.WithStack(err), errInfo())
+		}
+	}
+	env := buildEnv(os.Environ(), g.tokenEnv())
+	if hasEnvTarget(targets) {
+		env = buildEnv(env, flattenSecrets(secretsList, g.Flatten))
+		log.Infof("Executing %v with %v secret(s) in environment", cmdPath, len(secretsList))
+	} else {
+		log.Infof("Executing %v", cmdPath)
+	}
+	stopSignalWatch()
+	if isStopped(stopped) {
+		return errStopped
+	}
+	g.revokeToken(false)
+	err = syscall.Exec(cmdPath, cmdArgs, env)
+	return errors.Wrap(errors.WithStack(err), errInfo())
+}
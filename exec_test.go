@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		wantMode    string
+		wantCmdArgs []string
+		wantErr     bool
+	}{
+		{"noArgs", []string{}, modePublish, nil, false},
+		{"exec", []string{"exec", "--", "myapp", "-v"}, modeExec, []string{"myapp", "-v"}, false},
+		{"execNoDash", []string{"exec", "myapp"}, modeExec, []string{"myapp"}, false},
+		{"execNoCmd", []string{"exec", "--"}, modeExec, nil, true},
+		{"daemon", []string{"daemon"}, modeDaemon, nil, false},
+		{"daemonArgs", []string{"daemon", "foo"}, modeDaemon, nil, true},
+		{"supervise", []string{"supervise", "--", "myapp"}, modeSupervise, []string{"myapp"}, false},
+		{"unknown", []string{"foo"}, "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotMode, gotCmdArgs, err := parseArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseArgs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if gotMode != tt.wantMode {
+				t.Errorf("parseArgs() mode = %v, want %v", gotMode, tt.wantMode)
+			}
+			if !reflect.DeepEqual(gotCmdArgs, tt.wantCmdArgs) {
+				t.Errorf("parseArgs() cmdArgs = %v, want %v", gotCmdArgs, tt.wantCmdArgs)
+			}
+		})
+	}
+}
+
+func Test_buildEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		kv      map[string]string
+		want    []string
+	}{
+		{"merge", []string{"PATH=/bin", "HOME=/root"}, map[string]string{"secret": "value"},
+			[]string{"PATH=/bin", "HOME=/root", "secret=value"}},
+		{"override", []string{"PATH=/bin", "secret=old"}, map[string]string{"secret": "new=value"},
+			[]string{"PATH=/bin", "secret=new=value"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildEnv(tt.environ, tt.kv); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const fakeVaultToken = "fake-token"
+
+// fakeVault is an in-memory Vault stand-in serving a kv v1 (kv_v1/)
+// and a kv v2 (kv_v2/) secret engine. kv2 holds all the versions of
+// the secrets, the last one being the current version.
+// database/creds/<role> returns dynamic secrets, with a new lease each time.
+// revoked holds the leases and tokens (by accessor) revoked.
+// logins holds the expected payload of the auth methods, by mount path.
+// wrapped holds the single-use response wrapping tokens.
+// lookupFails makes the token lookup fail.
+type fakeVault struct {
+	*httptest.Server
+	kv1         map[string]map[string]interface{}
+	kv2         map[string][]map[string]interface{}
+	logins      map[string]map[string]string
+	wrapped     map[string]fakeWrapped
+	leases      int
+	revoked     []string
+	lookupFails bool
+}
+
+// fakeWrapped is a wrapped response and the path which created it
+type fakeWrapped struct {
+	creationPath string
+	data         map[string]interface{}
+}
+
+func newFakeVault() *fakeVault {
+	v := newUnstartedFakeVault()
+	v.Start()
+	return v
+}
+
+// newFakeVaultTLS returns a fake Vault served over TLS, requesting
+// the client certificate (cert auth method)
+func newFakeVaultTLS() *fakeVault {
+	v := newUnstartedFakeVault()
+	v.Server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	v.StartTLS()
+	return v
+}
+
+func newUnstartedFakeVault() *fakeVault {
+	v := &fakeVault{
+		kv1:     make(map[string]map[string]interface{}),
+		kv2:     make(map[string][]map[string]interface{}),
+		logins:  make(map[string]map[string]string),
+		wrapped: make(map[string]fakeWrapped),
+	}
+	v.Server = httptest.NewUnstartedServer(http.HandlerFunc(v.serve))
+	return v
+}
+
+func (v *fakeVault) reply(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func (v *fakeVault) serve(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/v1/auth/") && strings.HasSuffix(r.URL.Path, "/login") {
+		v.login(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/v1/sys/wrapping/") && r.URL.Path != "/v1/sys/wrapping/wrap" {
+		v.unwrap(w, r)
+		return
+	}
+	if r.Header.Get("X-Vault-Token") != fakeVaultToken {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case path == "auth/token/lookup-self" && v.lookupFails:
+		http.Error(w, `{"errors":["internal error"]}`, http.StatusInternalServerError)
+	case path == "auth/token/lookup-self":
+		v.reply(w, map[string]interface{}{"id": fakeVaultToken, "accessor": "fake-accessor", "ttl": 300})
+	case path == "sys/wrapping/wrap":
+		v.wrap(w, r)
+	case path == "sys/internal/ui/mounts":
+		v.reply(w, map[string]interface{}{"secret": map[string]interface{}{
+			"kv_v1/":    map[string]interface{}{"type": "kv"},
+			"kv_v2/":    map[string]interface{}{"type": "kv", "options": map[string]interface{}{"version": "2"}},
+			"database/": map[string]interface{}{"type": "database"},
+		}})
+	case path == "auth/token/renew-self":
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": fakeVaultToken, "lease_duration": 300, "renewable": true}})
+	case path == "auth/token/revoke-self":
+		v.revoked = append(v.revoked, "fake-accessor")
+		w.WriteHeader(http.StatusNoContent)
+	case path == "sys/leases/revoke":
+		var payload map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		v.revoked = append(v.revoked, payload["lease_id"])
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(path, "database/creds/"):
+		v.leases++
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_id": path + "/" + strconv.Itoa(v.leases), "lease_duration": 300,
+			"data": map[string]interface{}{"username": "v-app-" + strconv.Itoa(v.leases), "password": "dynamic"}})
+	case r.Method == "LIST" && strings.HasPrefix(path, "kv_v2/metadata/"):
+		paths := make(map[string]bool)
+		for p := range v.kv2 {
+			paths[p] = true
+		}
+		v.list(w, paths, strings.TrimPrefix(path, "kv_v2/metadata/"))
+	case r.Method == "LIST" && strings.HasPrefix(path, "kv_v1/"):
+		paths := make(map[string]bool)
+		for p := range v.kv1 {
+			paths[p] = true
+		}
+		v.list(w, paths, strings.TrimPrefix(path, "kv_v1/"))
+	case strings.HasPrefix(path, "kv_v2/data/"):
+		versions := v.kv2[strings.TrimPrefix(path, "kv_v2/data/")]
+		version := len(versions)
+		if q := r.URL.Query().Get("version"); len(q) > 0 {
+			version, _ = strconv.Atoi(q)
+		}
+		if version < 1 || version > len(versions) {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		v.reply(w, map[string]interface{}{"data": versions[version-1], "metadata": map[string]interface{}{
+			"version": version, "created_time": "2019-01-0" + strconv.Itoa(version) + "T00:00:00Z"}})
+	case strings.HasPrefix(path, "kv_v1/"):
+		data, ok := v.kv1[strings.TrimPrefix(path, "kv_v1/")]
+		if !ok {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		v.reply(w, data)
+	default:
+		http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+	}
+}
+
+// login replies a client token when the payload is the expected one
+func (v *fakeVault) login(w http.ResponseWriter, r *http.Request) {
+	mount := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/auth/"), "/login")
+	var payload map[string]string
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+	want, ok := v.logins[mount]
+	if mount == authCert && (r.TLS == nil || len(r.TLS.PeerCertificates) == 0) {
+		ok = false
+	}
+	if !ok || !reflect.DeepEqual(payload, want) {
+		http.Error(w, `{"errors":["invalid credentials"]}`, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{
+		"client_token": fakeVaultToken, "accessor": "fake-accessor", "lease_duration": 300}})
+}
+
+// wrap stores the payload in a new wrapping token
+func (v *fakeVault) wrap(w http.ResponseWriter, r *http.Request) {
+	ttl, err := time.ParseDuration(r.Header.Get("X-Vault-Wrap-TTL"))
+	if err != nil {
+		http.Error(w, `{"errors":["invalid wrap ttl"]}`, http.StatusBadRequest)
+		return
+	}
+	var data map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&data)
+	token := "s.wrap" + strconv.Itoa(len(v.wrapped)+1)
+	v.wrapped[token] = fakeWrapped{"sys/wrapping/wrap", data}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"wrap_info": map[string]interface{}{
+		"token": token, "accessor": "wrap-accessor", "ttl": int(ttl.Seconds()), "creation_path": "sys/wrapping/wrap"}})
+}
+
+// unwrap looks up or unwraps a wrapping token, the token being
+// revoked once unwrapped
+func (v *fakeVault) unwrap(w http.ResponseWriter, r *http.Request) {
+	var payload map[string]string
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+	token := payload["token"]
+	if r.URL.Path == "/v1/sys/wrapping/unwrap" {
+		token = r.Header.Get("X-Vault-Token")
+	}
+	wrapped, ok := v.wrapped[token]
+	if !ok {
+		http.Error(w, `{"errors":["wrapping token is not valid or does not exist"]}`, http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/sys/wrapping/lookup":
+		v.reply(w, map[string]interface{}{"creation_path": wrapped.creationPath, "creation_ttl": 60})
+	case "/v1/sys/wrapping/unwrap":
+		delete(v.wrapped, token)
+		v.reply(w, wrapped.data)
+	default:
+		http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+	}
+}
+
+// list replies the keys and sub folders of the secret paths under dir
+func (v *fakeVault) list(w http.ResponseWriter, paths map[string]bool, dir string) {
+	found := make(map[string]bool)
+	for path := range paths {
+		if !strings.HasPrefix(path, dir) {
+			continue
+		}
+		key := strings.TrimPrefix(path, dir)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		found[key] = true
+	}
+	if len(found) == 0 {
+		http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		return
+	}
+	var keys []string
+	for k := range found {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	v.reply(w, map[string]interface{}{"keys": keys})
+}
+
+// gvs returns a gvs logged in the fake Vault
+func (v *fakeVault) gvs(t *testing.T) *gvs {
+	g := &gvs{VaultURL: v.URL, HTTPCli: v.Client(), SecretConflict: conflictOverride}
+	if err := g.lookupToken(fakeVaultToken); err != nil {
+		t.Fatalf("Error looking up fake vault token: %v", err)
+	}
+	return g
+}
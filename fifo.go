@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// isFifoPathOK checks a named pipe can be created and removed at path
+func isFifoPathOK(path string) (bool, error) {
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		return false, errors.Wrap(err, errInfo())
+	}
+	if err := os.Remove(path); err != nil {
+		return false, errors.Wrap(err, errInfo())
+	}
+	return true, nil
+}
+
+// check verifies a named pipe can be created at the target path
+func (t fifoSink) check() error {
+	_, err := t.isPathOK()
+	return err
+}
+
+// publish creates a named pipe at the target path and blocks until the
+// application reads the rendered secrets from it once, then removes it.
+// Gives up after AvailableTime seconds.
+func (t fifoSink) publish(kv map[string]interface{}) error {
+	delay, _ := strconv.Atoi(t.AvailableTime)
+	output, err := t.render(kv)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	// remove stale fifo left by a previous run
+	if fi, err := os.Lstat(t.Path); err == nil && fi.Mode()&os.ModeNamedPipe != 0 {
+		_ = os.Remove(t.Path)
+	}
+	if err := syscall.Mkfifo(t.Path, 0666); err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	defer os.Remove(t.Path)
+	log.Infof("Secret fifo: %v, available for %v seconds", t.Path, t.AvailableTime)
+
+	type openResult struct {
+		f   *os.File
+		err error
+	}
+	opened := make(chan openResult, 1)
+	go func() {
+		// blocks until a reader opens the fifo
+		f, err := os.OpenFile(t.Path, os.O_WRONLY, 0)
+		opened <- openResult{f, err}
+	}()
+
+	select {
+	case res := <-opened:
+		if res.err != nil {
+			return errors.Wrap(errors.WithStack(res.err), errInfo())
+		}
+		_, err := res.f.Write(output)
+		res.f.Close()
+		if err != nil {
+			return errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		log.Infof("Secret fifo: secret read from %v", t.Path)
+		return nil
+	case <-time.After(time.Duration(delay) * time.Second):
+		err = errors.New("secret fifo " + t.Path + " was not read within " + t.AvailableTime + " seconds")
+	case <-t.stopped:
+		err = errors.New("secret fifo " + t.Path + " was not read before gvs stopped")
+	}
+	// open the read side to release the pending writer
+	if r, err := os.OpenFile(t.Path, os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+		defer r.Close()
+	}
+	if res := <-opened; res.f != nil {
+		res.f.Close()
+	}
+	return err
+}
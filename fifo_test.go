@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_isFifoPathOK(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		wantIsOK bool
+		wantErr  bool
+	}{
+		{"ok", "./test.fifo", true, false},
+		{"ko", "/notexist/test.fifo", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotIsOK, err := isFifoPathOK(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("isFifoPathOK() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if gotIsOK != tt.wantIsOK {
+				t.Errorf("isFifoPathOK() = %v, want %v", gotIsOK, tt.wantIsOK)
+			}
+		})
+	}
+}
+
+func Test_fifoSink_publish(t *testing.T) {
+	mySecret := map[string]string{"secret": "value"}
+	tests := []struct {
+		name     string
+		read     bool
+		wantData string
+		wantErr  bool
+	}{
+		{"read", true, "secret=value\n", false},
+		{"notRead", false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := fifoSink{&secretTarget{Type: targetFifo, Path: "./test.fifo", AvailableTime: "1", Format: formatKV}}
+			errc := make(chan error, 1)
+			go func() { errc <- s.publish(secretsFromStrings(mySecret)) }()
+			var data []byte
+			if tt.read {
+				for i := 0; i < 20; i++ {
+					if fi, err := os.Stat(s.Path); err == nil && fi.Mode()&os.ModeNamedPipe != 0 {
+						data, _ = ioutil.ReadFile(s.Path)
+						break
+					}
+					time.Sleep(50 * time.Millisecond)
+				}
+			}
+			if err := <-errc; (err != nil) != tt.wantErr {
+				t.Errorf("fifoSink.publish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(data) != tt.wantData {
+				t.Errorf("fifoSink.publish() data = %q, want %q", data, tt.wantData)
+			}
+			if _, err := os.Stat(s.Path); !os.IsNotExist(err) {
+				t.Errorf("fifoSink.publish() fifo %v not removed", s.Path)
+			}
+		})
+	}
+}
+
+func Test_fifoSink_publish_stopped(t *testing.T) {
+	stopped := make(chan struct{})
+	s := fifoSink{&secretTarget{Type: targetFifo, Path: "./test.fifo", AvailableTime: "30", Format: formatKV,
+		stopped: stopped}}
+	errc := make(chan error, 1)
+	go func() { errc <- s.publish(secretsFromStrings(map[string]string{"secret": "value"})) }()
+	time.Sleep(100 * time.Millisecond)
+	close(stopped)
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Errorf("fifoSink.publish() error = nil, want stopped error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("fifoSink.publish() not cancelled when stopped")
+	}
+	if _, err := os.Stat(s.Path); !os.IsNotExist(err) {
+		t.Errorf("fifoSink.publish() fifo %v not removed", s.Path)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var invalidEnvKeyChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// flattening strategies of the nested secret values: a.b.c or A__B__C
+const flattenDot = "dot"
+const flattenUnderscore = "underscore"
+
+// flattenSecrets returns the secrets as strings for the outputs which do not
+// handle nested values: maps and lists are flattened into one key per value,
+// according to the strategy
+func flattenSecrets(kv map[string]interface{}, strategy string) map[string]string {
+	flat := make(map[string]string, len(kv))
+	for k, v := range kv {
+		flattenValue(flat, k, v, strategy, false)
+	}
+	return flat
+}
+
+// flattenValue adds the value, or its nested values, to flat
+func flattenValue(flat map[string]string, key string, v interface{}, strategy string, nested bool) {
+	sep := "."
+	if strategy == flattenUnderscore {
+		sep = "__"
+	}
+	switch value := v.(type) {
+	case map[string]interface{}:
+		if len(value) > 0 {
+			for k, sub := range value {
+				flattenValue(flat, key+sep+k, sub, strategy, true)
+			}
+			return
+		}
+	case []interface{}:
+		if len(value) > 0 {
+			for i, sub := range value {
+				flattenValue(flat, key+sep+strconv.Itoa(i), sub, strategy, true)
+			}
+			return
+		}
+	}
+	if nested && strategy == flattenUnderscore {
+		key = strings.ToUpper(key)
+	}
+	flat[key] = stringValue(v)
+}
+
+// stringValue returns the string representation of a secret value,
+// json for the non string ones
+func stringValue(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	case nil:
+		return ""
+	}
+	s, _ := json.Marshal(v)
+	return string(s)
+}
+
+// sortedKeys returns the map keys in alphabetical order
+func sortedKeys(kv map[string]string) []string {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// envKey returns key as a valid environment variable name:
+// invalid characters are replaced with _ and names starting with a digit are prefixed with _
+func envKey(key string) (string, error) {
+	if len(key) == 0 {
+		return "", errors.New("empty secret key")
+	}
+	name := invalidEnvKeyChars.ReplaceAllString(key, "_")
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name, nil
+}
+
+// renderEnvFile renders the secrets one variable per line, keys being
+// sanitized to valid environment variable names
+func renderEnvFile(kv map[string]string, line func(k, v string) string) ([]byte, error) {
+	var output bytes.Buffer
+	names := make(map[string]string)
+	for _, k := range sortedKeys(kv) {
+		name, err := envKey(k)
+		if err != nil {
+			return nil, errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		if other, ok := names[name]; ok {
+			return nil, errors.New("secret keys " + other + " and " + k + " both map to variable " + name)
+		}
+		names[name] = k
+		output.WriteString(line(name, kv[k]))
+	}
+	return output.Bytes(), nil
+}
+
+var dotenvReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "\n", `\n`, "\r", `\r`)
+
+// dotenvLine returns a docker compose compatible KEY="value" line
+func dotenvLine(k, v string) string {
+	return k + `="` + dotenvReplacer.Replace(v) + "\"\n"
+}
+
+// shellLine returns a POSIX shell export KEY='value' line
+func shellLine(k, v string) string {
+	return "export " + k + "='" + strings.Replace(v, "'", `'\''`, -1) + "'\n"
+}
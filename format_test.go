@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func Test_envKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{"valid", "DB_PASSWORD", "DB_PASSWORD", false},
+		{"dash", "my-first-secret", "my_first_secret", false},
+		{"digit", "1password", "_1password", false},
+		{"empty", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := envKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("envKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("envKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_renderEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		kv      map[string]string
+		line    func(k, v string) string
+		want    string
+		wantErr bool
+	}{
+		{"dotenv", map[string]string{"my-secret": "a \"b\" $c\\d\ne"}, dotenvLine,
+			"my_secret=\"a \\\"b\\\" \\$c\\\\d\\ne\"\n", false},
+		{"shell", map[string]string{"my-secret": "it's $(id)"}, shellLine,
+			"export my_secret='it'\\''s $(id)'\n", false},
+		{"collision", map[string]string{"my-secret": "a", "my_secret": "b"}, shellLine, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderEnvFile(tt.kv, tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("renderEnvFile() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if string(got) != tt.want {
+				t.Errorf("renderEnvFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_shellLineSourced(t *testing.T) {
+	value := "it's a \"secret\"\n$(touch /tmp/gvs-injected) `id` \\ end"
+	output, err := renderEnvFile(map[string]string{"SECRET": value}, shellLine)
+	if err != nil {
+		t.Fatalf("renderEnvFile() error = %v", err)
+	}
+	got, err := exec.Command("/bin/sh", "-c", string(output)+`printf %s "$SECRET"`).Output()
+	if err != nil {
+		t.Fatalf("sourcing shell output failed: %v", err)
+	}
+	if string(got) != value {
+		t.Errorf("sourced value = %q, want %q", got, value)
+	}
+}
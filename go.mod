@@ -1,5 +1,7 @@
 module github.com/mch1307/gvs
 
+go 1.13
+
 require (
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/mch1307/vaultlib v0.5.0
@@ -0,0 +1,61 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// newReadWatcher returns an inotify instance watching for path being closed
+// after having been opened read only
+func newReadWatcher(path string) (*os.File, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if _, err := syscall.InotifyAddWatch(fd, path, syscall.IN_CLOSE_NOWRITE|syscall.IN_DELETE_SELF); err != nil {
+		syscall.Close(fd)
+		return nil, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	return os.NewFile(uintptr(fd), "inotify"), nil
+}
+
+// waitForRead blocks until the watched file has been read or timeout expired.
+// Returns true if the file was read.
+func waitForRead(watcher *os.File, timeout time.Duration) bool {
+	read := make(chan bool, 1)
+	go func() {
+		buf := make([]byte, syscall.SizeofInotifyEvent*16+syscall.PathMax)
+		for {
+			n, err := watcher.Read(buf)
+			if err != nil {
+				read <- false
+				return
+			}
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				if event.Mask&syscall.IN_CLOSE_NOWRITE != 0 {
+					read <- true
+					return
+				}
+				if event.Mask&(syscall.IN_DELETE_SELF|syscall.IN_IGNORED) != 0 {
+					read <- false
+					return
+				}
+				offset += syscall.SizeofInotifyEvent + int(event.Len)
+			}
+		}
+	}()
+	select {
+	case isRead := <-read:
+		return isRead
+	case <-time.After(timeout):
+		return false
+	}
+}
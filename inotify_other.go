@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// newReadWatcher is only available on linux (inotify)
+func newReadWatcher(path string) (*os.File, error) {
+	return nil, errors.New("delete on read is only supported on linux")
+}
+
+// waitForRead waits for timeout as the file read can not be detected
+func waitForRead(watcher *os.File, timeout time.Duration) bool {
+	time.Sleep(timeout)
+	return false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// keyMapping selects a key of a secret path, optionally renaming it
+//
+//	path:key[->NAME]
+type keyMapping struct {
+	Path string
+	Key  string
+	Name string
+}
+
+// parseKeyMap parses the comma separated list of key mappings
+func parseKeyMap(list string) ([]keyMapping, error) {
+	var mappings []keyMapping
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		var m keyMapping
+		source := entry
+		if i := strings.Index(entry, "->"); i >= 0 {
+			source = strings.TrimSpace(entry[:i])
+			m.Name = strings.TrimSpace(entry[i+2:])
+			if len(m.Name) == 0 {
+				return nil, errors.New("no key name in mapping " + entry)
+			}
+		}
+		i := strings.Index(source, ":")
+		if i < 0 {
+			return nil, errors.New("no secret path in mapping " + entry)
+		}
+		path, _, err := splitSecretVersion(strings.TrimPrefix(source[:i], "/"))
+		if err != nil {
+			return nil, errors.Wrap(err, "mapping "+entry)
+		}
+		m.Path = path
+		m.Key = source[i+1:]
+		if len(m.Path) == 0 || len(m.Key) == 0 {
+			return nil, errors.New("invalid key mapping " + entry)
+		}
+		if len(m.Name) == 0 {
+			m.Name = m.Key
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
+
+// mapKeys returns the keys of the secret read at path selected by the
+// mappings, renamed. Returns kv unchanged and false when path has no mapping.
+// A mapped key missing from the secret is an error.
+func mapKeys(mappings []keyMapping, path string, kv map[string]interface{}) (map[string]interface{}, bool, error) {
+	mapped := make(map[string]interface{})
+	found := false
+	for _, m := range mappings {
+		if m.Path != path {
+			continue
+		}
+		found = true
+		v, ok := kv[m.Key]
+		if !ok {
+			return nil, true, errors.New("mapped key " + m.Key + " not found in " + path)
+		}
+		mapped[m.Name] = v
+	}
+	if !found {
+		return kv, false, nil
+	}
+	return mapped, true, nil
+}
+
+// checkKeyMap verifies all the mapped secret paths have been read
+func checkKeyMap(mappings []keyMapping, read map[string]bool) error {
+	for _, m := range mappings {
+		if !read[m.Path] {
+			return errors.New("secret path " + m.Path + " of mapped key " + m.Key + " not read")
+		}
+	}
+	return nil
+}
+
+// formatKeys returns a copy of kv with the keys prefixed and upper cased
+// as configured
+func (g *gvs) formatKeys(kv map[string]interface{}) map[string]interface{} {
+	if len(g.KeyPrefix) == 0 && !g.KeyUppercase {
+		return kv
+	}
+	formatted := make(map[string]interface{}, len(kv))
+	for k, v := range kv {
+		k = g.KeyPrefix + k
+		if g.KeyUppercase {
+			k = strings.ToUpper(k)
+		}
+		formatted[k] = v
+	}
+	return formatted
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseKeyMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    []keyMapping
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"list", "db/prod:password -> DB_PASSWORD, /db/prod@3:user,smtp:host->SMTP_HOST", []keyMapping{
+			{"db/prod", "password", "DB_PASSWORD"},
+			{"db/prod", "user", "user"},
+			{"smtp", "host", "SMTP_HOST"},
+		}, false},
+		{"noPath", "password->DB_PASSWORD", nil, true},
+		{"noKey", "db/prod:->DB_PASSWORD", nil, true},
+		{"noName", "db/prod:password->", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseKeyMap(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseKeyMap() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseKeyMap() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_gvs_getSecretsList_keyMap(t *testing.T) {
+	v := newFakeVault()
+	defer v.Close()
+	v.kv2["db/prod"] = []map[string]interface{}{{"user": "admin", "password": "s3cr3t", "host": "db"}}
+	v.kv2["smtp"] = []map[string]interface{}{{"host": "smtp.example.com"}}
+	tests := []struct {
+		name      string
+		keyMap    string
+		prefix    string
+		uppercase bool
+		want      map[string]interface{}
+		wantErr   bool
+	}{
+		{"noMap", "", "", false, map[string]interface{}{
+			"user": "admin", "password": "s3cr3t", "host": "smtp.example.com",
+			"GVS_APPNAME": "my-app", "GVS_APPENV": "dev"}, false},
+		{"selectAndRename", "kv_v2/db/prod:password->DB_PASSWORD,kv_v2/db/prod:user", "", false, map[string]interface{}{
+			"DB_PASSWORD": "s3cr3t", "user": "admin", "host": "smtp.example.com",
+			"GVS_APPNAME": "my-app", "GVS_APPENV": "dev"}, false},
+		{"prefixUppercase", "kv_v2/smtp:host->smtp_host", "app_", true, map[string]interface{}{
+			"APP_USER": "admin", "APP_PASSWORD": "s3cr3t", "APP_HOST": "db", "APP_SMTP_HOST": "smtp.example.com",
+			"GVS_APPNAME": "my-app", "GVS_APPENV": "dev"}, false},
+		{"missingKey", "kv_v2/db/prod:port->DB_PORT", "", false, nil, true},
+		{"pathNotRead", "kv_v2/db/test:password->DB_PASSWORD", "", false, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := v.gvs(t)
+			g.AppName, g.AppEnv = "my-app", "dev"
+			g.VaultSecretPaths = []string{"kv_v2/db/prod", "kv_v2/smtp"}
+			g.KeyMap, _ = parseKeyMap(tt.keyMap)
+			g.KeyPrefix, g.KeyUppercase = tt.prefix, tt.uppercase
+			got, err := g.getSecretsList()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("gvs.getSecretsList() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("gvs.getSecretsList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// kvMount holds the Vault kv secret engine a secret path belongs to
+type kvMount struct {
+	Name    string
+	Version string
+}
+
+// delay before the lease of a superseded dynamic secret is revoked, giving
+// the application time to reload the new one
+const leaseRevokeDelay = 2 * time.Minute
+
+// vaultResponse holds the part of the Vault json responses used by gvs
+type vaultResponse struct {
+	LeaseID       string          `json:"lease_id"`
+	LeaseDuration int             `json:"lease_duration"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// dynamicSecret is a dynamic secret (ie database/creds/my-app) read from
+// Vault, reused until two thirds of its lease are elapsed so that new
+// credentials are not issued on every refresh
+type dynamicSecret struct {
+	kv      map[string]interface{}
+	leaseID string
+	renewAt time.Time
+}
+
+// staleLease is the lease of a superseded dynamic secret, revoked once
+// revokeAt is passed
+type staleLease struct {
+	id       string
+	revokeAt time.Time
+}
+
+// vaultRequest calls the Vault HTTP API with the client token and
+// unmarshals the data of the response in data.
+func (g *gvs) vaultRequest(method, path string, payload, data interface{}) error {
+	_, err := g.vaultLeaseRequest(method, path, payload, data)
+	return err
+}
+
+// vaultLeaseRequest is vaultRequest returning the response lease, if any.
+// The leases of the dynamic secrets read are recorded to be revoked when
+// gvs stops.
+func (g *gvs) vaultLeaseRequest(method, path string, payload, data interface{}) (vaultRsp vaultResponse, err error) {
+	if err := g.vaultCall(method, path, payload, &vaultRsp); err != nil {
+		return vaultRsp, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if len(vaultRsp.LeaseID) > 0 {
+		g.Leases = append(g.Leases, vaultRsp.LeaseID)
+	}
+	// keep numbers as is, see normalizeValue
+	decoder := json.NewDecoder(bytes.NewReader(vaultRsp.Data))
+	decoder.UseNumber()
+	if err := decoder.Decode(data); err != nil {
+		return vaultRsp, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	return vaultRsp, nil
+}
+
+// getKVMount returns the kv secret engine of the given secret path
+func (g *gvs) getKVMount(path string) (mount kvMount, err error) {
+	var mounts struct {
+		Secret map[string]struct {
+			Options map[string]interface{} `json:"options"`
+		} `json:"secret"`
+	}
+	if err := g.vaultRequest("GET", "/v1/sys/internal/ui/mounts", nil, &mounts); err != nil {
+		return mount, errors.WithStack(err)
+	}
+	for name, m := range mounts.Secret {
+		if !strings.HasPrefix(path, name) || len(name) <= len(mount.Name) {
+			continue
+		}
+		mount.Name = name
+		mount.Version = "1"
+		if v, ok := m.Options["version"].(string); ok {
+			mount.Version = v
+		}
+	}
+	if len(mount.Name) == 0 {
+		return mount, errors.New("no kv secret engine found for " + path)
+	}
+	return mount, nil
+}
+
+var secretVersion = regexp.MustCompile(`^[0-9]+$`)
+
+// secretMetadata holds the version information of a secret read from Vault
+// (kv v2 only)
+type secretMetadata struct {
+	Path        string `json:"path"`
+	Version     int    `json:"version,omitempty"`
+	CreatedTime string `json:"created_time,omitempty"`
+}
+
+// splitSecretVersion splits a path@version secret path, version being a
+// number. Returns a 0 version when the path is not pinned.
+func splitSecretVersion(path string) (string, int, error) {
+	i := strings.LastIndex(path, "@")
+	if i < 0 || !secretVersion.MatchString(path[i+1:]) {
+		return path, 0, nil
+	}
+	version, err := strconv.Atoi(path[i+1:])
+	if err != nil || version < 1 {
+		return "", 0, errors.New("invalid secret version in " + path)
+	}
+	if isSecretFolder(path[:i]) {
+		return "", 0, errors.New("secret version can not be pinned on folder " + path)
+	}
+	return path[:i], version, nil
+}
+
+// readVaultSecret reads the secret kv at path, pinned to version when not 0
+// (kv v2 only), and its metadata. path is read as is, see splitSecretVersion
+// for the path@version syntax of the configured secret paths.
+// Dynamic secrets are read again only when their lease is about to expire.
+func (g *gvs) readVaultSecret(path string, version int) (kv map[string]interface{}, meta secretMetadata, err error) {
+	meta.Path = path
+	if secret, ok := g.dynamicSecrets[path]; ok && time.Now().Before(secret.renewAt) {
+		return copySecret(secret.kv), meta, nil
+	}
+	mount, err := g.getKVMount(path)
+	if err != nil {
+		return nil, meta, errors.WithStack(err)
+	}
+
+	var data map[string]interface{}
+	var lease vaultResponse
+	if mount.Version == "2" {
+		url := "/v1/" + mount.Name + "data/" + strings.TrimPrefix(path, mount.Name)
+		if version > 0 {
+			url += "?version=" + strconv.Itoa(version)
+		}
+		var secret struct {
+			Data     map[string]interface{} `json:"data"`
+			Metadata struct {
+				Version     int    `json:"version"`
+				CreatedTime string `json:"created_time"`
+			} `json:"metadata"`
+		}
+		if err := g.vaultRequest("GET", url, nil, &secret); err != nil {
+			return nil, meta, errors.Wrap(err, "error reading "+path)
+		}
+		if secret.Data == nil {
+			return nil, meta, errors.New("no data found for " + path + ", version deleted or destroyed")
+		}
+		data = secret.Data
+		meta.Version = secret.Metadata.Version
+		meta.CreatedTime = secret.Metadata.CreatedTime
+	} else {
+		if version > 0 {
+			return nil, meta, errors.New("secret version can only be pinned on kv v2 secrets, not " + path)
+		}
+		if lease, err = g.vaultLeaseRequest("GET", "/v1/"+path, nil, &data); err != nil {
+			return nil, meta, errors.Wrap(err, "error reading "+path)
+		}
+	}
+
+	kv = make(map[string]interface{}, len(data))
+	for k, v := range data {
+		kv[k] = normalizeValue(v)
+	}
+	if len(lease.LeaseID) > 0 {
+		g.keepDynamicSecret(path, kv, lease)
+	}
+	return kv, meta, nil
+}
+
+// keepDynamicSecret keeps the dynamic secret read at path until two thirds
+// of its lease are elapsed. The lease of the secret it replaces is revoked
+// after leaseRevokeDelay, see revokeStaleLeases.
+func (g *gvs) keepDynamicSecret(path string, kv map[string]interface{}, lease vaultResponse) {
+	if g.dynamicSecrets == nil {
+		g.dynamicSecrets = make(map[string]*dynamicSecret)
+	}
+	if previous, ok := g.dynamicSecrets[path]; ok {
+		g.staleLeases = append(g.staleLeases, staleLease{previous.leaseID, time.Now().Add(leaseRevokeDelay)})
+	}
+	g.dynamicSecrets[path] = &dynamicSecret{
+		kv:      copySecret(kv),
+		leaseID: lease.LeaseID,
+		renewAt: time.Now().Add(time.Duration(lease.LeaseDuration) * time.Second * 2 / 3),
+	}
+}
+
+// copySecret returns a copy of the secret kv
+func copySecret(kv map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(kv))
+	for k, v := range kv {
+		copied[k] = v
+	}
+	return copied
+}
+
+// normalizeValue converts the json numbers of a secret value to int64,
+// or float64 when not an integer, so that they are rendered as in Vault
+func normalizeValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		for k, sub := range value {
+			value[k] = normalizeValue(sub)
+		}
+	case []interface{}:
+		for i, sub := range value {
+			value[i] = normalizeValue(sub)
+		}
+	}
+	return v
+}
+
+// isSecretFolder returns true when the secret path is a folder (trailing slash)
+func isSecretFolder(path string) bool {
+	return strings.HasSuffix(path, "/")
+}
+
+// listSecrets recursively lists the secrets under the folder
+// Returns the sorted secret paths
+func (g *gvs) listSecrets(folder string) ([]string, error) {
+	mount, err := g.getKVMount(folder)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var secrets []string
+	folders := []string{folder}
+	for len(folders) > 0 {
+		current := folders[0]
+		folders = folders[1:]
+		url := "/v1/" + current
+		if mount.Version == "2" {
+			url = "/v1/" + mount.Name + "metadata/" + strings.TrimPrefix(current, mount.Name)
+		}
+		var list struct {
+			Keys []string `json:"keys"`
+		}
+		if err := g.vaultRequest("LIST", url, nil, &list); err != nil {
+			return nil, errors.Wrap(err, "error listing "+current)
+		}
+		for _, k := range list.Keys {
+			if isSecretFolder(k) {
+				folders = append(folders, current+k)
+			} else {
+				secrets = append(secrets, current+k)
+			}
+		}
+	}
+	sort.Strings(secrets)
+	return secrets, nil
+}
+
+// folderKeyPrefix returns the prefix of the keys of a secret read from a
+// folder: its path relative to the folder, ie db_ for app/dev/db in app/dev/
+func folderKeyPrefix(folder, path string) string {
+	return strings.Replace(strings.TrimPrefix(path, folder), "/", "_", -1) + "_"
+}
+
+// prefixKeys returns a copy of kv with prefix added to its keys
+func prefixKeys(kv map[string]interface{}, prefix string) map[string]interface{} {
+	prefixed := make(map[string]interface{}, len(kv))
+	for k, v := range kv {
+		prefixed[prefix+k] = v
+	}
+	return prefixed
+}
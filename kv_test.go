@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_gvs_getSecretsList_folder(t *testing.T) {
+	v := newFakeVault()
+	defer v.Close()
+	secrets := map[string]map[string]interface{}{
+		"app/dev/db":           {"user": "admin", "password": "s3cr3t"},
+		"app/dev/smtp/primary": {"host": "smtp.example.com"},
+		"app/prod/db":          {"user": "prod"},
+		"app/rel/release@2":    {"tag": "v2"},
+	}
+	for path, kv := range secrets {
+		v.kv1[path] = kv
+		v.kv2[path] = []map[string]interface{}{kv}
+	}
+	tests := []struct {
+		name    string
+		paths   []string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{"kv2Folder", []string{"kv_v2/app/dev/"}, map[string]interface{}{
+			"db_user": "admin", "db_password": "s3cr3t", "smtp_primary_host": "smtp.example.com",
+			"GVS_APPNAME": "my-app", "GVS_APPENV": "dev"}, false},
+		{"kv1Folder", []string{"kv_v1/app/dev/"}, map[string]interface{}{
+			"db_user": "admin", "db_password": "s3cr3t", "smtp_primary_host": "smtp.example.com",
+			"GVS_APPNAME": "my-app", "GVS_APPENV": "dev"}, false},
+		{"folderAndSecret", []string{"kv_v2/app/dev/smtp/", "kv_v1/app/prod/db"}, map[string]interface{}{
+			"primary_host": "smtp.example.com", "user": "prod",
+			"GVS_APPNAME": "my-app", "GVS_APPENV": "dev"}, false},
+		{"kv2VersionLikeName", []string{"kv_v2/app/rel/"}, map[string]interface{}{
+			"release@2_tag": "v2", "GVS_APPNAME": "my-app", "GVS_APPENV": "dev"}, false},
+		{"kv1VersionLikeName", []string{"kv_v1/app/rel/"}, map[string]interface{}{
+			"release@2_tag": "v2", "GVS_APPNAME": "my-app", "GVS_APPENV": "dev"}, false},
+		{"folderNotFound", []string{"kv_v2/app/test/"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := v.gvs(t)
+			g.AppName, g.AppEnv = "my-app", "dev"
+			g.VaultSecretPaths = tt.paths
+			got, err := g.getSecretsList()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("gvs.getSecretsList() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("gvs.getSecretsList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_folderKeyPrefix(t *testing.T) {
+	tests := []struct {
+		folder string
+		path   string
+		want   string
+	}{
+		{"app/dev/", "app/dev/db", "db_"},
+		{"app/dev/", "app/dev/smtp/primary", "smtp_primary_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := folderKeyPrefix(tt.folder, tt.path); got != tt.want {
+				t.Errorf("folderKeyPrefix() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_splitSecretVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		wantPath    string
+		wantVersion int
+		wantErr     bool
+	}{
+		{"notPinned", "kv_v2/my-app-dev", "kv_v2/my-app-dev", 0, false},
+		{"pinned", "kv_v2/my-app-dev@7", "kv_v2/my-app-dev", 7, false},
+		{"atInFolder", "kv_v2/team@corp/my-app", "kv_v2/team@corp/my-app", 0, false},
+		{"atInName", "kv_v2/users/bob@corp.com", "kv_v2/users/bob@corp.com", 0, false},
+		{"notVersion", "kv_v2/my-app-dev@latest", "kv_v2/my-app-dev@latest", 0, false},
+		{"zeroVersion", "kv_v2/my-app-dev@0", "", 0, true},
+		{"folder", "kv_v2/app/dev/@2", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotVersion, err := splitSecretVersion(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("splitSecretVersion() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if gotPath != tt.wantPath || gotVersion != tt.wantVersion {
+				t.Errorf("splitSecretVersion() = %v, %v, want %v, %v", gotPath, gotVersion, tt.wantPath, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func Test_gvs_readVaultSecret(t *testing.T) {
+	v := newFakeVault()
+	defer v.Close()
+	v.kv1["my-secret"] = map[string]interface{}{"password": "v1"}
+	v.kv2["my-app-dev"] = []map[string]interface{}{{"password": "old"}, {"password": "new", "port": 5432,
+		"db": map[string]interface{}{"hosts": []interface{}{"a", "b"}, "ratio": 0.5}}}
+	tests := []struct {
+		name     string
+		path     string
+		wantKv   map[string]interface{}
+		wantMeta secretMetadata
+		wantErr  bool
+	}{
+		{"latest", "kv_v2/my-app-dev", map[string]interface{}{"password": "new", "port": int64(5432),
+			"db": map[string]interface{}{"hosts": []interface{}{"a", "b"}, "ratio": 0.5}},
+			secretMetadata{"kv_v2/my-app-dev", 2, "2019-01-02T00:00:00Z"}, false},
+		{"pinned", "kv_v2/my-app-dev@1", map[string]interface{}{"password": "old"},
+			secretMetadata{"kv_v2/my-app-dev", 1, "2019-01-01T00:00:00Z"}, false},
+		{"kv1", "kv_v1/my-secret", map[string]interface{}{"password": "v1"},
+			secretMetadata{Path: "kv_v1/my-secret"}, false},
+		{"unknownVersion", "kv_v2/my-app-dev@3", nil, secretMetadata{}, true},
+		{"pinnedKV1", "kv_v1/my-secret@1", nil, secretMetadata{}, true},
+	}
+	g := v.gvs(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, version, _ := splitSecretVersion(tt.path)
+			gotKv, gotMeta, err := g.readVaultSecret(path, version)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("gvs.readVaultSecret() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(gotKv, tt.wantKv) {
+				t.Errorf("gvs.readVaultSecret() kv = %v, want %v", gotKv, tt.wantKv)
+			}
+			if gotMeta != tt.wantMeta {
+				t.Errorf("gvs.readVaultSecret() metadata = %v, want %v", gotMeta, tt.wantMeta)
+			}
+		})
+	}
+}
+
+func Test_gvs_getSecretsList_metadata(t *testing.T) {
+	v := newFakeVault()
+	defer v.Close()
+	v.kv2["my-app-dev"] = []map[string]interface{}{{"password": "old"}, {"password": "new"}}
+	g := v.gvs(t)
+	g.VaultSecretPaths = []string{"kv_v2/my-app-dev@1"}
+	g.SecretFilePath = "./test.gvs"
+	defer os.Remove(g.SecretFilePath + metadataFileExt)
+
+	g.SecretMetadata = metadataInline
+	got, err := g.getSecretsList()
+	if err != nil {
+		t.Fatalf("gvs.getSecretsList() error = %v", err)
+	}
+	want := `[{"path":"kv_v2/my-app-dev","version":1,"created_time":"2019-01-01T00:00:00Z"}]`
+	if got[metadataKey] != want {
+		t.Errorf("gvs.getSecretsList() metadata = %v, want %v", got[metadataKey], want)
+	}
+
+	g.SecretMetadata = metadataFile
+	got, err = g.getSecretsList()
+	if err != nil {
+		t.Fatalf("gvs.getSecretsList() error = %v", err)
+	}
+	if _, ok := got[metadataKey]; ok {
+		t.Errorf("gvs.getSecretsList() metadata key added with file output")
+	}
+	var meta []secretMetadata
+	content, _ := ioutil.ReadFile(g.SecretFilePath + metadataFileExt)
+	if err := json.Unmarshal(content, &meta); err != nil || len(meta) != 1 || meta[0].Version != 1 {
+		t.Errorf("gvs.getSecretsList() metadata file = %s, error %v", content, err)
+	}
+}
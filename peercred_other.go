@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// peerCredentials is only available on linux (SO_PEERCRED)
+func peerCredentials(conn *net.UnixConn) (uid, pid int, err error) {
+	return 0, 0, errors.New("socket peer credentials are only supported on linux")
+}
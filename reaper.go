@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// hidden command used to re-execute gvs as a detached secret file reaper
+//
+//	gvs __reaper <delay> <path> [onread]
+const reaperCmd = "__reaper"
+
+// reaper option: delete the file as soon as it has been read, the inotify
+// watcher being passed as fd 3
+const reaperOnRead = "onread"
+
+// the reaper is dispatched from init so that it also runs from test binaries
+func init() {
+	if len(os.Args) > 1 && os.Args[1] == reaperCmd {
+		os.Exit(runReaper(os.Args[2:]))
+	}
+}
+
+// startReaper launches a detached gvs process in charge of deleting path
+// after delay seconds, or as soon as watcher reports it was read
+func startReaper(path, delay string, watcher *os.File) error {
+	self, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	cmd := exec.Command(self, reaperCmd, delay, path)
+	if watcher != nil {
+		cmd.Args = append(cmd.Args, reaperOnRead)
+		cmd.ExtraFiles = []*os.File{watcher}
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	log.Debugf("Started secret reaper (pid %v) for %v", cmd.Process.Pid, path)
+	return cmd.Process.Release()
+}
+
+// runReaper sleeps then securely deletes the secret file, logging the outcome.
+// Returns the process exit code.
+func runReaper(args []string) int {
+	log.SetFormatter(&log.TextFormatter{})
+	log.SetOutput(os.Stdout)
+	if len(args) < 2 || len(args) > 3 || (len(args) == 3 && args[2] != reaperOnRead) {
+		log.Errorf("Secret reaper: usage %v <delay> <path> [%v]", reaperCmd, reaperOnRead)
+		return 2
+	}
+	delay, err := strconv.Atoi(args[0])
+	if err != nil {
+		log.Errorf("Secret reaper: invalid delay %v", args[0])
+		return 2
+	}
+	path := args[1]
+	if len(args) == 3 {
+		if waitForRead(os.NewFile(3, "inotify"), time.Duration(delay)*time.Second) {
+			log.Infof("Secret reaper: %v has been read", path)
+		}
+	} else {
+		time.Sleep(time.Duration(delay) * time.Second)
+	}
+	if err := secureDelete(path); err != nil {
+		log.Errorf("Secret reaper: error removing %v: %v", path, err)
+		return 1
+	}
+	log.Infof("Secret reaper: %v removed", path)
+	return 0
+}
+
+// secureDelete overwrites the file content with zeros before removing it.
+// Directories (dir output format) are deleted recursively.
+func secureDelete(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if fi.IsDir() {
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		for _, f := range files {
+			if err := secureDelete(filepath.Join(path, f.Name())); err != nil {
+				return err
+			}
+		}
+	} else if fi.Mode().IsRegular() {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		_, err = f.Write(make([]byte, fi.Size()))
+		if err == nil {
+			err = f.Sync()
+		}
+		f.Close()
+		if err != nil {
+			return errors.Wrap(errors.WithStack(err), errInfo())
+		}
+	}
+	if err := os.Remove(path); err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	return nil
+}
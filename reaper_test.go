@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_secureDelete(t *testing.T) {
+	tests := []struct {
+		name    string
+		create  bool
+		dir     bool
+		wantErr bool
+	}{
+		{"ok", true, false, false},
+		{"dir", true, true, false},
+		{"notFound", false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "./test.delete"
+			if tt.create {
+				file := path
+				if tt.dir {
+					_ = os.MkdirAll(path+"/sub", 0755)
+					file = path + "/sub/secret"
+				}
+				f, _ := os.Create(file)
+				_, _ = f.WriteString("secret")
+				f.Close()
+			}
+			if err := secureDelete(path); (err != nil) != tt.wantErr {
+				t.Errorf("secureDelete() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("secureDelete() %v still exists", path)
+			}
+		})
+	}
+}
+
+func Test_destroySecretFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		delay   string
+		wantErr bool
+	}{
+		{"now", "0", false},
+		{"reaper", "1", false},
+		{"wrongDelay", "abc", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "./test.destroy"
+			f, _ := os.Create(path)
+			f.Close()
+			defer os.Remove(path)
+			if err := destroySecretFile(path, tt.delay); (err != nil) != tt.wantErr {
+				t.Errorf("destroySecretFile() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) {
+				if _, err := os.Stat(path); os.IsNotExist(err) {
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			t.Errorf("destroySecretFile() %v not removed", path)
+		})
+	}
+}
+
+func Test_destroySecretFileOnRead(t *testing.T) {
+	path := "./test.onread"
+	f, _ := os.Create(path)
+	_, _ = f.WriteString("secret")
+	f.Close()
+	defer os.Remove(path)
+	if err := destroySecretFileOnRead(path, "30"); err != nil {
+		t.Fatalf("destroySecretFileOnRead() error = %v", err)
+	}
+	if _, err := ioutil.ReadFile(path); err != nil {
+		t.Fatalf("destroySecretFileOnRead() could not read %v: %v", path, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Errorf("destroySecretFileOnRead() %v not removed after read", path)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// secretSchema holds the constraints the secrets must match to be published
+type secretSchema struct {
+	Required []string `yaml:"required"`
+	// keys not listed in required nor keys are allowed (default true)
+	AllowExtra *bool               `yaml:"allowextra"`
+	Keys       map[string]*keyRule `yaml:"keys"`
+}
+
+// keyRule holds the constraints of a secret value
+type keyRule struct {
+	Pattern   string `yaml:"pattern"`
+	MinLength int    `yaml:"minlength"`
+	MaxLength int    `yaml:"maxlength"`
+	regexp    *regexp.Regexp
+}
+
+// readSchema reads the secret schema yaml file at path
+func readSchema(path string) (*secretSchema, error) {
+	var schema secretSchema
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if err := yaml.UnmarshalStrict(data, &schema); err != nil {
+		return nil, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	for k, rule := range schema.Keys {
+		if rule == nil {
+			schema.Keys[k] = &keyRule{}
+			continue
+		}
+		if len(rule.Pattern) > 0 {
+			if rule.regexp, err = regexp.Compile(rule.Pattern); err != nil {
+				return nil, errors.Wrap(err, "key "+k)
+			}
+		}
+		if rule.MinLength < 0 || rule.MaxLength < 0 || (rule.MaxLength > 0 && rule.MinLength > rule.MaxLength) {
+			return nil, errors.New("invalid length constraints for key " + k)
+		}
+	}
+	return &schema, nil
+}
+
+// validate checks the secrets against the schema.
+// Returns an error listing all the missing, invalid and unexpected keys.
+func (s *secretSchema) validate(secrets map[string]interface{}) error {
+	var problems []string
+	required := make(map[string]bool)
+	for _, k := range s.Required {
+		required[k] = true
+		if _, ok := secrets[k]; !ok {
+			problems = append(problems, "missing key "+k)
+		}
+	}
+	keys := make([]string, 0, len(secrets))
+	for k := range secrets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		rule, ok := s.Keys[k]
+		if !ok {
+			if !required[k] && s.AllowExtra != nil && !*s.AllowExtra {
+				problems = append(problems, "unexpected key "+k)
+			}
+			continue
+		}
+		// values are never reported
+		value := stringValue(secrets[k])
+		if rule.MinLength > 0 && len(value) < rule.MinLength {
+			problems = append(problems, "key "+k+" shorter than "+strconv.Itoa(rule.MinLength))
+		}
+		if rule.MaxLength > 0 && len(value) > rule.MaxLength {
+			problems = append(problems, "key "+k+" longer than "+strconv.Itoa(rule.MaxLength))
+		}
+		if rule.regexp != nil && !rule.regexp.MatchString(value) {
+			problems = append(problems, "key "+k+" does not match "+rule.Pattern)
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("secrets do not match the schema: " + strings.Join(problems, ", "))
+	}
+	return nil
+}
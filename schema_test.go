@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_readSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"ok", "required: [DB_PASSWORD]\nallowextra: false\nkeys:\n  DB_PASSWORD:\n    pattern: '^\\S+$'\n    minlength: 12\n  DB_PORT:\n", false},
+		{"unknownField", "required: [DB_PASSWORD]\nkeys:\n  DB_PASSWORD:\n    regex: '.*'\n", true},
+		{"wrongPattern", "keys:\n  DB_PASSWORD:\n    pattern: '['\n", true},
+		{"wrongLength", "keys:\n  DB_PASSWORD:\n    minlength: 12\n    maxlength: 8\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "./test.schema"
+			_ = ioutil.WriteFile(path, []byte(tt.content), 0600)
+			defer os.Remove(path)
+			if _, err := readSchema(path); (err != nil) != tt.wantErr {
+				t.Errorf("readSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_secretSchema_validate(t *testing.T) {
+	path := "./test.schema"
+	content := `
+required: [DB_USER, DB_PASSWORD]
+allowextra: false
+keys:
+  DB_PASSWORD:
+    minlength: 8
+    maxlength: 16
+  DB_PORT:
+    pattern: '^[0-9]+$'
+`
+	_ = ioutil.WriteFile(path, []byte(content), 0600)
+	defer os.Remove(path)
+	schema, err := readSchema(path)
+	if err != nil {
+		t.Fatalf("readSchema() error = %v", err)
+	}
+	tests := []struct {
+		name     string
+		secrets  map[string]interface{}
+		wantErrs []string
+	}{
+		{"ok", map[string]interface{}{"DB_USER": "admin", "DB_PASSWORD": "s3cr3t-pass", "DB_PORT": int64(5432)}, nil},
+		{"optionalMissing", map[string]interface{}{"DB_USER": "admin", "DB_PASSWORD": "s3cr3t-pass"}, nil},
+		{"allProblems", map[string]interface{}{"DB_PASSWORD": "short", "DB_PORT": "db", "DB_HOST": "db"}, []string{
+			"missing key DB_USER", "unexpected key DB_HOST", "key DB_PASSWORD shorter than 8", "key DB_PORT does not match"}},
+		{"tooLong", map[string]interface{}{"DB_USER": "admin", "DB_PASSWORD": "a-very-long-password"}, []string{
+			"key DB_PASSWORD longer than 16"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.validate(tt.secrets)
+			if (err != nil) != (len(tt.wantErrs) > 0) {
+				t.Errorf("secretSchema.validate() error = %v, want %v", err, tt.wantErrs)
+				return
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("secretSchema.validate() error = %v, want %v", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// policies applied when a key is defined in several secret paths
+const conflictOverride = "override"
+const conflictError = "error"
+const conflictFirst = "first"
+
+// secret metadata (path, version, created_time) outputs: none, added to
+// the secrets under the GVS_SECRETMETADATA key, or written to a sidecar
+// .meta file next to GVS_SECRETTARGETPATH
+const metadataNone = "none"
+const metadataInline = "inline"
+const metadataFile = "file"
+const metadataKey = "GVS_SECRETMETADATA"
+const metadataFileExt = ".meta"
+
+// secret path used when GVS_SECRETPATH and GVS_SECRETPATHTEMPLATE are not set
+const defaultSecretPathTemplate = "secret/{{.AppName}}-{{.AppEnv}}"
+
+// parseList splits a comma separated list, ignoring empty items
+func parseList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseSecretPaths splits the comma separated list of Vault secret paths,
+// trimming the leading slash. The trailing slash of folders is kept.
+func parseSecretPaths(list string) []string {
+	var paths []string
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimPrefix(strings.TrimSpace(p), "/")
+		if len(p) > 0 && p != "/" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// secretsFromStrings returns the secrets of a string key value list
+func secretsFromStrings(kv map[string]string) map[string]interface{} {
+	secrets := make(map[string]interface{}, len(kv))
+	for k, v := range kv {
+		secrets[k] = v
+	}
+	return secrets
+}
+
+// mergeSecrets merges the kv read at path into secrets, according to
+// the conflict policy. sources records the path each key comes from.
+func mergeSecrets(secrets map[string]interface{}, sources map[string]string, kv map[string]interface{}, path, policy string) error {
+	for k, v := range kv {
+		if from, ok := sources[k]; ok {
+			switch policy {
+			case conflictError:
+				return errors.New("secret key " + k + " defined in both " + from + " and " + path)
+			case conflictFirst:
+				log.Debugf("Secret key %v from %v ignored, already defined in %v", k, path, from)
+				continue
+			}
+			log.Debugf("Secret key %v from %v overrides the one from %v", k, path, from)
+		}
+		secrets[k] = v
+		sources[k] = path
+	}
+	return nil
+}
+
+// buildSecretPath renders the secret path template with the application
+// name and environment. Referencing an empty value is an error.
+func buildSecretPath(pathTemplate, appName, appEnv string) (string, error) {
+	tmpl, err := template.New("secretpath").Option("missingkey=error").Parse(pathTemplate)
+	if err != nil {
+		return "", errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	data := make(map[string]string)
+	if len(appName) > 0 {
+		data["AppName"] = appName
+	}
+	if len(appEnv) > 0 {
+		data["AppEnv"] = appEnv
+	}
+	var path bytes.Buffer
+	if err := tmpl.Execute(&path, data); err != nil {
+		return "", errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	return path.String(), nil
+}
+
+// writeMetadata writes the metadata of the secrets last read to the sidecar
+// file, replacing it only when it changed
+func (g *gvs) writeMetadata() error {
+	output, _ := json.MarshalIndent(g.SecretsMetadata, "", "  ")
+	t := &secretTarget{Type: targetFile, Path: g.SecretFilePath + metadataFileExt}
+	changed, err := t.replaceFile(t.Path, append(output, '\n'))
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if changed {
+		log.Infof("Secret metadata file %v updated", t.Path)
+	}
+	return nil
+}
+
+var base64Spaces = strings.NewReplacer("\n", "", "\r", "", " ", "")
+
+// decodeSecrets base64 decodes the values of the listed keys and of the keys
+// ending with suffix, the suffix being removed from their name.
+// Decoded values are binary ([]byte).
+func decodeSecrets(secrets map[string]interface{}, keys []string, suffix string) error {
+	decode := make(map[string]string)
+	for _, k := range keys {
+		if _, ok := secrets[k]; !ok {
+			return errors.New("base64 key " + k + " not found in the secrets")
+		}
+		decode[k] = k
+	}
+	if len(suffix) > 0 {
+		for k := range secrets {
+			if strings.HasSuffix(k, suffix) && len(k) > len(suffix) {
+				decode[k] = strings.TrimSuffix(k, suffix)
+			}
+		}
+	}
+	for k, name := range decode {
+		value, ok := secrets[k].(string)
+		if !ok {
+			return errors.New("base64 key " + k + " is not a string")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(base64Spaces.Replace(value))
+		if err != nil {
+			return errors.Wrap(err, "error decoding base64 key "+k)
+		}
+		if _, ok := secrets[name]; ok && name != k {
+			return errors.New("decoded key " + k + " conflicts with key " + name)
+		}
+		delete(secrets, k)
+		secrets[name] = decoded
+	}
+	return nil
+}
+
+// checkBinarySecrets returns an error when a secret holds binary content,
+// which output does not support
+func checkBinarySecrets(secrets map[string]interface{}, output string) error {
+	for k, v := range secrets {
+		if _, ok := v.([]byte); ok {
+			return errors.New("secret " + k + " holds binary content, not supported by " + output + " output")
+		}
+	}
+	return nil
+}
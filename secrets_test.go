@@ -0,0 +1,128 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseSecretPaths(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"single", "/kv_v2/my-app-dev", []string{"kv_v2/my-app-dev"}},
+		{"folder", "kv_v2/my-app/dev/", []string{"kv_v2/my-app/dev/"}},
+		{"list", "shared/common, team/db,,/app/my-app-dev", []string{"shared/common", "team/db", "app/my-app-dev"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSecretPaths(tt.list); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSecretPaths() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mergeSecrets(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		want        map[string]interface{}
+		wantSources map[string]string
+		wantErr     bool
+	}{
+		{"override", conflictOverride,
+			map[string]interface{}{"user": "app", "password": "app", "host": "db"},
+			map[string]string{"user": "app/my-app", "password": "app/my-app", "host": "shared/common"}, false},
+		{"first", conflictFirst,
+			map[string]interface{}{"user": "shared", "password": "app", "host": "db"},
+			map[string]string{"user": "shared/common", "password": "app/my-app", "host": "shared/common"}, false},
+		{"error", conflictError, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secrets := make(map[string]interface{})
+			sources := make(map[string]string)
+			_ = mergeSecrets(secrets, sources, map[string]interface{}{"user": "shared", "host": "db"}, "shared/common", tt.policy)
+			err := mergeSecrets(secrets, sources, map[string]interface{}{"user": "app", "password": "app"}, "app/my-app", tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("mergeSecrets() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(secrets, tt.want) {
+				t.Errorf("mergeSecrets() = %v, want %v", secrets, tt.want)
+			}
+			if !reflect.DeepEqual(sources, tt.wantSources) {
+				t.Errorf("mergeSecrets() sources = %v, want %v", sources, tt.wantSources)
+			}
+		})
+	}
+}
+
+func Test_buildSecretPath(t *testing.T) {
+	tests := []struct {
+		name         string
+		pathTemplate string
+		appName      string
+		appEnv       string
+		want         string
+		wantErr      bool
+	}{
+		{"default", defaultSecretPathTemplate, "my-app", "dev", "secret/my-app-dev", false},
+		{"custom", "kv/{{.AppEnv}}/{{.AppName}}", "my-app", "dev", "kv/dev/my-app", false},
+		{"noEnvNeeded", "kv/{{.AppName}}", "my-app", "", "kv/my-app", false},
+		{"missingEnv", defaultSecretPathTemplate, "my-app", "", "", true},
+		{"wrongTemplate", "kv/{{.AppName", "my-app", "dev", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildSecretPath(tt.pathTemplate, tt.appName, tt.appEnv)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("buildSecretPath() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("buildSecretPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_decodeSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets map[string]interface{}
+		keys    []string
+		suffix  string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{"none", map[string]interface{}{"keytab_b64": "AAEC"}, nil, "",
+			map[string]interface{}{"keytab_b64": "AAEC"}, false},
+		{"suffix", map[string]interface{}{"keytab_b64": "AAEC", "user": "admin"}, nil, "_b64",
+			map[string]interface{}{"keytab": []byte{0, 1, 2}, "user": "admin"}, false},
+		{"keys", map[string]interface{}{"keystore.p12": "AAEC\n/w==", "user": "admin"}, []string{"keystore.p12"}, "",
+			map[string]interface{}{"keystore.p12": []byte{0, 1, 2, 255}, "user": "admin"}, false},
+		{"missingKey", map[string]interface{}{"user": "admin"}, []string{"keystore.p12"}, "", nil, true},
+		{"notBase64", map[string]interface{}{"keytab_b64": "not base64!"}, nil, "_b64", nil, true},
+		{"notString", map[string]interface{}{"keytab_b64": int64(1)}, nil, "_b64", nil, true},
+		{"conflict", map[string]interface{}{"keytab_b64": "AAEC", "keytab": "x"}, nil, "_b64", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decodeSecrets(tt.secrets, tt.keys, tt.suffix)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeSecrets() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(tt.secrets, tt.want) {
+				t.Errorf("decodeSecrets() = %v, want %v", tt.secrets, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// parseIDList parses a comma separated list of uid/pid
+func parseIDList(list string) ([]int, error) {
+	var ids []int
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func containsID(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// check verifies a socket can be created at the target path
+func (t socketSink) check() error {
+	_, err := t.isPathOK()
+	return err
+}
+
+// publish listens on a unix socket at the target path and hands the
+// rendered secrets to the first authorised client, then shuts down.
+// Clients are authorised from their peer credentials (uid and optionally pid).
+// Gives up after AvailableTime seconds.
+func (t socketSink) publish(kv map[string]interface{}) error {
+	delay, _ := strconv.Atoi(t.AvailableTime)
+	output, err := t.render(kv)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	// remove stale socket left by a previous run
+	if fi, err := os.Lstat(t.Path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(t.Path)
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: t.Path, Net: "unix"})
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	defer l.Close()
+	// access is controlled with peer credentials
+	if err := os.Chmod(t.Path, 0666); err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if err := l.SetDeadline(time.Now().Add(time.Duration(delay) * time.Second)); err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-t.stopped:
+			// unblocks AcceptUnix
+			l.Close()
+		case <-done:
+		}
+	}()
+	log.Infof("Secret socket: %v, available for %v seconds", t.Path, t.AvailableTime)
+
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			if isStopped(t.stopped) {
+				return errors.New("secret socket " + t.Path + " was not read before gvs stopped")
+			}
+			return errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		uid, pid, err := peerCredentials(conn)
+		if err != nil {
+			conn.Close()
+			return errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		if !containsID(t.AllowedUIDs, uid) ||
+			(len(t.AllowedPIDs) > 0 && !containsID(t.AllowedPIDs, pid)) {
+			log.Warnf("Secret socket: rejected client uid %v pid %v", uid, pid)
+			conn.Close()
+			continue
+		}
+		_, err = conn.Write(output)
+		conn.Close()
+		if err != nil {
+			return errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		log.Infof("Secret socket: secret sent to client uid %v pid %v", uid, pid)
+		return nil
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseIDList(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    []int
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"list", "1000, 1001", []int{1000, 1001}, false},
+		{"wrongID", "1000,abc", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseIDList(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseIDList() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseIDList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_socketSink_publish(t *testing.T) {
+	mySecret := map[string]string{"secret": "value"}
+	tests := []struct {
+		name        string
+		allowedUIDs []int
+		allowedPIDs []int
+		wantData    string
+		wantErr     bool
+	}{
+		{"allowed", []int{os.Getuid()}, nil, "secret=value\n", false},
+		{"allowedPID", []int{os.Getuid()}, []int{os.Getpid()}, "secret=value\n", false},
+		{"rejectedUID", []int{os.Getuid() + 1}, nil, "", true},
+		{"rejectedPID", []int{os.Getuid()}, []int{os.Getpid() + 1}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := socketSink{&secretTarget{Path: "./test.sock", AvailableTime: "1", Format: formatKV,
+				AllowedUIDs: tt.allowedUIDs, AllowedPIDs: tt.allowedPIDs}}
+			errc := make(chan error, 1)
+			go func() { errc <- s.publish(secretsFromStrings(mySecret)) }()
+			var data []byte
+			for i := 0; i < 20; i++ {
+				conn, err := net.Dial("unix", s.Path)
+				if err != nil {
+					time.Sleep(50 * time.Millisecond)
+					continue
+				}
+				data, _ = ioutil.ReadAll(conn)
+				conn.Close()
+				break
+			}
+			if err := <-errc; (err != nil) != tt.wantErr {
+				t.Errorf("socketSink.publish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(data) != tt.wantData {
+				t.Errorf("socketSink.publish() data = %q, want %q", data, tt.wantData)
+			}
+			if _, err := os.Stat(s.Path); !os.IsNotExist(err) {
+				t.Errorf("socketSink.publish() socket %v not removed", s.Path)
+			}
+		})
+	}
+}
+
+func Test_socketSink_publish_stopped(t *testing.T) {
+	stopped := make(chan struct{})
+	s := socketSink{&secretTarget{Path: "./test.sock", AvailableTime: "30", Format: formatKV,
+		AllowedUIDs: []int{os.Getuid()}, stopped: stopped}}
+	errc := make(chan error, 1)
+	go func() { errc <- s.publish(secretsFromStrings(map[string]string{"secret": "value"})) }()
+	time.Sleep(100 * time.Millisecond)
+	close(stopped)
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Errorf("socketSink.publish() error = nil, want stopped error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("socketSink.publish() not cancelled when stopped")
+	}
+	if _, err := os.Stat(s.Path); !os.IsNotExist(err) {
+		t.Errorf("socketSink.publish() socket %v not removed", s.Path)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const modeSupervise = "supervise"
+
+// time given to the child to stop before being killed when restarting it
+const childStopTimeout = 10 * time.Second
+
+var signalNames = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// parseSignal returns the signal matching name (ie SIGHUP or HUP)
+func parseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := signalNames[name]
+	if !ok {
+		return 0, errors.New("unsupported signal " + name)
+	}
+	return sig, nil
+}
+
+// supervisor holds the state of the supervised child process
+type supervisor struct {
+	g          *gvs
+	cmdPath    string
+	cmdArgs    []string
+	secrets    map[string]interface{}
+	files      []*secretTarget
+	env        bool
+	tokenEnv   map[string]string
+	reloadSig  syscall.Signal
+	pid        int
+	stopping   bool
+	restarting bool
+}
+
+// superviseVaultSecret launches cmdArgs with the secrets in its environment
+// and stays as its parent: signals are forwarded to the child, zombies are
+// reaped and the secrets are read again every RefreshInterval seconds.
+// The file targets are kept up to date and removed, and the Vault token and
+// leases revoked, when gvs stops.
+// When the secrets change, the child is either sent ReloadSignal (the secret
+// files being rewritten beforehand) or restarted with the new environment.
+// Returns the child exit code.
+func superviseVaultSecret(cmdArgs []string) (int, error) {
+	g, err := newGVS()
+	if err != nil {
+		return 1, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	defer g.revokeToken(true)
+	s := &supervisor{g: g, cmdArgs: cmdArgs}
+	s.cmdPath, err = exec.LookPath(cmdArgs[0])
+	if err != nil {
+		return 1, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if len(g.ReloadSignal) > 0 {
+		s.reloadSig, _ = parseSignal(g.ReloadSignal)
+	}
+	targets := g.targets(modeSupervise)
+	if err := checkTargets(targets, modeSupervise); err != nil {
+		return 1, errors.WithStack(err)
+	}
+	s.files = fileTargets(targets)
+	s.env = hasEnvTarget(targets)
+	s.tokenEnv = g.tokenEnv()
+	s.secrets, err = g.getSecretsList()
+	if err != nil {
+		return 1, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if err := s.publish(); err != nil {
+		return 1, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	defer s.cleanup()
+	if len(g.TokenFilePath) > 0 {
+		if err := g.writeTokenFile(false); err != nil {
+			return 1, errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		defer g.removeTokenFile()
+	}
+
+	sigs := make(chan os.Signal, 16)
+	signal.Notify(sigs, syscall.SIGCHLD, syscall.SIGTERM, syscall.SIGINT,
+		syscall.SIGQUIT, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigs)
+
+	if err := s.start(); err != nil {
+		return 1, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+
+	interval, _ := strconv.Atoi(g.RefreshInterval)
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	var killTimer <-chan time.Time
+
+	for {
+		select {
+		case sig := <-sigs:
+			if sig == syscall.SIGCHLD {
+				exited, exitCode := s.reap()
+				if !exited {
+					continue
+				}
+				if s.restarting && !s.stopping {
+					s.restarting = false
+					killTimer = nil
+					if err := s.start(); err != nil {
+						return 1, errors.Wrap(errors.WithStack(err), errInfo())
+					}
+					continue
+				}
+				log.Infof("%v exited with code %v", s.cmdPath, exitCode)
+				return exitCode, nil
+			}
+			if sig == syscall.SIGTERM || sig == syscall.SIGINT || sig == syscall.SIGQUIT {
+				s.stopping = true
+			}
+			log.Debugf("Forwarding %v to %v", sig, s.pid)
+			_ = syscall.Kill(s.pid, sig.(syscall.Signal))
+		case <-ticker.C:
+			if !s.restarting && s.refresh() && s.reloadSig == 0 {
+				killTimer = time.After(childStopTimeout)
+			}
+		case <-killTimer:
+			log.Warnf("%v did not stop within %v, killing it", s.cmdPath, childStopTimeout)
+			_ = syscall.Kill(s.pid, syscall.SIGKILL)
+		}
+	}
+}
+
+// start launches the child with the current secrets, and the gvs Vault
+// token when configured, in its environment
+func (s *supervisor) start() error {
+	env := buildEnv(os.Environ(), s.tokenEnv)
+	if s.env {
+		if err := checkBinarySecrets(s.secrets, targetEnv); err != nil {
+			return errors.WithStack(err)
+		}
+		env = buildEnv(env, flattenSecrets(s.secrets, s.g.Flatten))
+	}
+	proc, err := os.StartProcess(s.cmdPath, s.cmdArgs, &os.ProcAttr{
+		Env:   env,
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+	})
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	s.pid = proc.Pid
+	// the child is waited for by reap
+	_ = proc.Release()
+	log.Infof("Started %v (pid %v)", s.cmdPath, s.pid)
+	return nil
+}
+
+// reap waits for all the terminated processes (gvs can run as PID 1).
+// Returns true and the exit code when the supervised child is one of them.
+func (s *supervisor) reap() (exited bool, exitCode int) {
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+		if err != nil || pid <= 0 {
+			return exited, exitCode
+		}
+		if pid != s.pid {
+			log.Debugf("Reaped process %v", pid)
+			continue
+		}
+		exited = true
+		if ws.Signaled() {
+			exitCode = 128 + int(ws.Signal())
+		} else {
+			exitCode = ws.ExitStatus()
+		}
+	}
+}
+
+// refresh reads the secrets again and reloads the child when they changed.
+// The leases of the superseded dynamic secrets are revoked once their grace
+// period is over.
+// Returns true when a reload was triggered.
+func (s *supervisor) refresh() bool {
+	s.g.revokeStaleLeases()
+	secrets, err := s.g.getSecretsList()
+	if err != nil {
+		log.Errorf("Error refreshing secrets, keeping current ones: %v", err)
+		return false
+	}
+	if reflect.DeepEqual(secrets, s.secrets) {
+		log.Debugf("Secrets unchanged")
+		return false
+	}
+	s.secrets = secrets
+	if err := s.publish(); err != nil {
+		log.Errorf("Error writing refreshed secret files: %v", err)
+		return false
+	}
+	if s.reloadSig != 0 {
+		log.Infof("Secrets changed, sending %v to %v", s.reloadSig, s.pid)
+		_ = syscall.Kill(s.pid, s.reloadSig)
+		return true
+	}
+	log.Infof("Secrets changed, restarting %v", s.cmdPath)
+	s.restarting = true
+	_ = syscall.Kill(s.pid, syscall.SIGTERM)
+	return true
+}
+
+// publish writes the secret files, if any
+func (s *supervisor) publish() error {
+	return replaceTargets(s.files, s.secrets)
+}
+
+// cleanup removes the secret files, if any, when gvs stops
+func (s *supervisor) cleanup() {
+	for _, t := range s.files {
+		t.remove()
+	}
+}
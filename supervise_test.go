@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_parseSignal(t *testing.T) {
+	tests := []struct {
+		name    string
+		sig     string
+		want    syscall.Signal
+		wantErr bool
+	}{
+		{"full", "SIGHUP", syscall.SIGHUP, false},
+		{"short", "usr1", syscall.SIGUSR1, false},
+		{"unknown", "SIGFOO", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSignal(tt.sig)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseSignal() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseSignal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_supervisor_startReap(t *testing.T) {
+	tests := []struct {
+		name         string
+		cmdArgs      []string
+		wantExitCode int
+	}{
+		{"exitCode", []string{"sh", "-c", "exit 3"}, 3},
+		{"signaled", []string{"sh", "-c", "kill -TERM $$"}, 128 + int(syscall.SIGTERM)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &supervisor{cmdPath: "/bin/sh", cmdArgs: tt.cmdArgs}
+			if err := s.start(); err != nil {
+				t.Fatalf("supervisor.start() error = %v", err)
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) {
+				if exited, exitCode := s.reap(); exited {
+					if exitCode != tt.wantExitCode {
+						t.Errorf("supervisor.reap() exitCode = %v, want %v", exitCode, tt.wantExitCode)
+					}
+					return
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+			t.Errorf("supervisor.reap() child did not exit")
+		})
+	}
+}
+
+func Test_supervisor_refresh(t *testing.T) {
+	v := newFakeVault()
+	defer v.Close()
+	g := v.gvs(t)
+	g.AuthMethod = authAppRole
+	g.VaultSecretPaths = []string{"database/creds/my-app"}
+	// SIGCONT leaves the child running
+	s := &supervisor{g: g, cmdPath: "/bin/sh", cmdArgs: []string{"sh", "-c", "exec sleep 5"},
+		reloadSig: syscall.SIGCONT}
+	var err error
+	if s.secrets, err = g.getSecretsList(); err != nil {
+		t.Fatalf("gvs.getSecretsList() error = %v", err)
+	}
+	if err := s.start(); err != nil {
+		t.Fatalf("supervisor.start() error = %v", err)
+	}
+	defer func() {
+		_ = syscall.Kill(s.pid, syscall.SIGKILL)
+		s.reap()
+	}()
+	// the dynamic secret is reused while its lease is valid
+	if s.refresh() {
+		t.Errorf("supervisor.refresh() = true, want false")
+	}
+	g.dynamicSecrets["database/creds/my-app"].renewAt = time.Now()
+	if !s.refresh() {
+		t.Errorf("supervisor.refresh() near lease expiry = false, want true")
+	}
+	// the superseded lease is kept during its grace period
+	if len(v.revoked) > 0 {
+		t.Errorf("supervisor.refresh() revoked %v, want none", v.revoked)
+	}
+	want := []string{"database/creds/my-app/1", "database/creds/my-app/2"}
+	if !reflect.DeepEqual(g.Leases, want) {
+		t.Errorf("supervisor.refresh() leases %v, want %v", g.Leases, want)
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const targetFile = "file"
+const targetSocket = "socket"
+const targetFifo = "fifo"
+const targetEnv = "env"
+
+// secretTarget holds the configuration of one output of the secrets
+type secretTarget struct {
+	// file (default), socket, fifo or env (child process environment)
+	Type         string `yaml:"type"`
+	Path         string `yaml:"path"`
+	Format       string `yaml:"format"`
+	Pretty       bool   `yaml:"pretty"`
+	TemplatePath string `yaml:"template"`
+	// file permissions in octal (ie 0400), default 0666 minus umask
+	Mode          string `yaml:"mode"`
+	AvailableTime string `yaml:"availabletime"`
+	DeleteOnRead  bool   `yaml:"deleteonread"`
+	AllowedUIDs   []int  `yaml:"alloweduids"`
+	AllowedPIDs   []int  `yaml:"allowedpids"`
+	// flattening of the nested values for the kv, dotenv, shell and dir formats
+	Flatten  string `yaml:"flatten"`
+	template *template.Template
+	// files written in the secret directory (dir format)
+	dirFiles map[string]bool
+	// closed when gvs is stopped, cancelling the socket and fifo publishing
+	stopped <-chan struct{}
+}
+
+// secretSink publishes the secrets to a target
+type secretSink interface {
+	// check verifies the target is usable, before the secrets are read
+	check() error
+	// publish hands the rendered secrets over to the target
+	publish(kv map[string]interface{}) error
+}
+
+type fileSink struct{ *secretTarget }
+type socketSink struct{ *secretTarget }
+type fifoSink struct{ *secretTarget }
+
+// sink returns the secretSink of the target, nil for env targets
+// which are handled by the exec and supervise modes
+func (t *secretTarget) sink() secretSink {
+	switch t.Type {
+	case targetSocket:
+		return socketSink{t}
+	case targetFifo:
+		return fifoSink{t}
+	case targetEnv:
+		return nil
+	}
+	return fileSink{t}
+}
+
+// readTargets reads the list of targets from the yaml file at path.
+// availableTime is the default secret availability time.
+func readTargets(path, availableTime string) ([]*secretTarget, error) {
+	var targets []*secretTarget
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if err := yaml.UnmarshalStrict(data, &targets); err != nil {
+		return nil, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no target defined in " + path)
+	}
+	for i, t := range targets {
+		if err := t.init(availableTime); err != nil {
+			return nil, errors.Wrap(err, "target "+strconv.Itoa(i+1))
+		}
+	}
+	return targets, nil
+}
+
+// init validates the target and sets its default values
+func (t *secretTarget) init(availableTime string) error {
+	t.Type = strings.ToLower(t.Type)
+	switch t.Type {
+	case "":
+		t.Type = targetFile
+	case targetFile, targetSocket, targetFifo:
+	case targetEnv:
+		return nil
+	default:
+		return errors.New("unsupported target type " + t.Type)
+	}
+	if len(t.Path) == 0 {
+		return errors.New("no path provided")
+	}
+	if t.DeleteOnRead && t.Type != targetFile {
+		return errors.New("deleteonread is only supported for file targets")
+	}
+
+	t.Format = strings.ToLower(t.Format)
+	switch t.Format {
+	case "":
+		t.Format = formatYAML
+	case formatYAML, formatKV, formatJSON, formatDotenv, formatShell:
+	case formatDir:
+		if t.Type != targetFile || t.DeleteOnRead {
+			return errors.New("dir output format is only supported for file targets, without deleteonread")
+		}
+	case formatTemplate:
+		var err error
+		if t.template, err = newSecretTemplate(t.TemplatePath); err != nil {
+			return errors.Wrap(err, "error reading template")
+		}
+	default:
+		return errors.New("unsupported output format " + t.Format)
+	}
+
+	t.Flatten = strings.ToLower(t.Flatten)
+	switch t.Flatten {
+	case "":
+		t.Flatten = flattenDot
+	case flattenDot, flattenUnderscore:
+	default:
+		return errors.New("unsupported flatten strategy " + t.Flatten)
+	}
+
+	if len(t.Mode) > 0 {
+		if _, err := strconv.ParseUint(t.Mode, 8, 32); err != nil {
+			return errors.New("invalid file mode " + t.Mode)
+		}
+	}
+
+	if len(t.AvailableTime) == 0 {
+		t.AvailableTime = availableTime
+	} else if numSec, err := strconv.Atoi(t.AvailableTime); err != nil || numSec > 180 {
+		t.AvailableTime = "180"
+	}
+
+	if len(t.AllowedUIDs) == 0 {
+		t.AllowedUIDs = []int{os.Getuid()}
+	}
+	return nil
+}
+
+// defaultTarget returns the target defined by the GVS_ variables
+func (g *gvs) defaultTarget() *secretTarget {
+	return &secretTarget{
+		Type:          g.SecretTargetType,
+		Path:          g.SecretFilePath,
+		Format:        g.OutputFormat,
+		Pretty:        g.OutputPretty,
+		TemplatePath:  g.TemplatePath,
+		AvailableTime: g.SecretAvailabletime,
+		DeleteOnRead:  g.DeleteOnRead,
+		AllowedUIDs:   g.SocketAllowedUIDs,
+		AllowedPIDs:   g.SocketAllowedPIDs,
+		Flatten:       g.Flatten,
+		template:      g.Template,
+	}
+}
+
+// targets returns the targets of the given run mode: the ones from
+// the targets file if any, otherwise the ones defined by the GVS_ variables
+func (g *gvs) targets(mode string) []*secretTarget {
+	if len(g.Targets) > 0 {
+		return g.Targets
+	}
+	env := &secretTarget{Type: targetEnv}
+	switch mode {
+	case modeExec:
+		return []*secretTarget{env}
+	case modeSupervise:
+		if len(g.ReloadSignal) > 0 {
+			return []*secretTarget{env, g.defaultTarget()}
+		}
+		return []*secretTarget{env}
+	}
+	return []*secretTarget{g.defaultTarget()}
+}
+
+// checkTargets verifies the targets are supported by the run mode and usable
+func checkTargets(targets []*secretTarget, mode string) error {
+	for _, t := range targets {
+		supported := true
+		switch mode {
+		case modePublish:
+			supported = t.Type != targetEnv
+		case modeExec:
+			supported = t.Type == targetFile || t.Type == targetEnv
+		case modeDaemon:
+			supported = t.Type == targetFile && !t.DeleteOnRead
+		case modeSupervise:
+			supported = (t.Type == targetFile && !t.DeleteOnRead) || t.Type == targetEnv
+		}
+		if !supported {
+			return errors.New(t.Type + " target " + t.Path + " is not supported in " + mode + " mode")
+		}
+		if s := t.sink(); s != nil {
+			if err := s.check(); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	return nil
+}
+
+// publishTargets publishes the secrets to all the targets (but env) concurrently
+func publishTargets(targets []*secretTarget, kv map[string]interface{}) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(targets))
+	for i, t := range targets {
+		s := t.sink()
+		if s == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, s secretSink) {
+			defer wg.Done()
+			errs[i] = s.publish(kv)
+		}(i, s)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// hasEnvTarget returns true when the secrets must be passed in the child environment
+func hasEnvTarget(targets []*secretTarget) bool {
+	for _, t := range targets {
+		if t.Type == targetEnv {
+			return true
+		}
+	}
+	return false
+}
+
+// fileTargets returns the file targets
+func fileTargets(targets []*secretTarget) []*secretTarget {
+	var files []*secretTarget
+	for _, t := range targets {
+		if t.Type == targetFile {
+			files = append(files, t)
+		}
+	}
+	return files
+}
+
+// render returns the secrets in the target output format
+func (t *secretTarget) render(kv map[string]interface{}) ([]byte, error) {
+	if t.Format != formatTemplate && t.Format != formatDir {
+		if err := checkBinarySecrets(kv, t.Format); err != nil {
+			return nil, err
+		}
+	}
+	switch t.Format {
+	case formatYAML:
+		output, _ := yaml.Marshal(&kv)
+		return output, nil
+	case formatJSON:
+		var output []byte
+		if t.Pretty {
+			output, _ = json.MarshalIndent(kv, "", "  ")
+		} else {
+			output, _ = json.Marshal(kv)
+		}
+		return append(output, '\n'), nil
+	case formatDotenv:
+		return renderEnvFile(flattenSecrets(kv, t.Flatten), dotenvLine)
+	case formatShell:
+		return renderEnvFile(flattenSecrets(kv, t.Flatten), shellLine)
+	case formatTemplate:
+		return renderTemplate(t.template, kv)
+	case formatDir:
+		return nil, errors.New("dir output format can not be rendered to a single file")
+	}
+	var output bytes.Buffer
+	flat := flattenSecrets(kv, t.Flatten)
+	for _, k := range sortedKeys(flat) {
+		output.WriteString(k + "=" + flat[k] + "\n")
+	}
+	return output.Bytes(), nil
+}
+
+// createFile creates (or truncates) path with the target permissions
+func (t *secretTarget) createFile(path string) (*os.File, error) {
+	if len(t.Mode) == 0 {
+		return os.Create(path)
+	}
+	mode, _ := strconv.ParseUint(t.Mode, 8, 32)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(mode))
+	if err != nil {
+		return nil, err
+	}
+	// not subject to umask
+	if err := f.Chmod(os.FileMode(mode)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// write renders the secrets to the target file
+func (t *secretTarget) write(kv map[string]interface{}) error {
+	if t.Format == formatDir {
+		_, err := t.replaceDir(kv)
+		return err
+	}
+	output, err := t.render(kv)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	f, err := t.createFile(t.Path)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	defer f.Close()
+	_, _ = f.Write(output)
+	f.Sync()
+	return err
+}
+
+// replace atomically replaces the target file, only when its content changed.
+// Returns true if the file was (re)written.
+func (t *secretTarget) replace(kv map[string]interface{}) (changed bool, err error) {
+	if t.Format == formatDir {
+		return t.replaceDir(kv)
+	}
+	output, err := t.render(kv)
+	if err != nil {
+		return false, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	return t.replaceFile(t.Path, output)
+}
+
+// replaceFile atomically replaces path with output, only when its content changed
+func (t *secretTarget) replaceFile(path string, output []byte) (changed bool, err error) {
+	current, err := ioutil.ReadFile(path)
+	if err == nil && bytes.Equal(current, output) {
+		return false, nil
+	}
+	tmpFile := path + ".new"
+	f, err := t.createFile(tmpFile)
+	if err != nil {
+		return false, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	_, err = f.Write(output)
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmpFile, path)
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return false, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	return true, nil
+}
+
+// remove securely deletes the target file, logging the outcome
+func (t *secretTarget) remove() {
+	if err := secureDelete(t.Path); err != nil {
+		log.Errorf("Error removing secret file %v: %v", t.Path, err)
+		return
+	}
+	log.Infof("Secret file %v removed", t.Path)
+}
+
+// isPathOK checks the target path is writeable and deleteable
+func (t *secretTarget) isPathOK() (isOK bool, err error) {
+	testFile := t.Path + ".tmp"
+	if t.Type == targetFifo {
+		return isFifoPathOK(testFile)
+	}
+	// create tmp test file
+	f, err := os.Create(testFile)
+	if err != nil {
+		return false, errors.Wrap(err, errInfo())
+	}
+	defer f.Close()
+
+	_, err = f.WriteString("test\n")
+	if err != nil {
+		return false, errors.Wrap(err, errInfo())
+	}
+
+	f.Sync()
+
+	// remove tmp test file
+	err = destroySecretFile(testFile, "0")
+	if err != nil {
+		return false, errors.Wrap(err, errInfo())
+	}
+	return true, nil
+}
+
+// check verifies the target file is writeable and deleteable
+func (t fileSink) check() error {
+	if t.Format == formatDir && t.dirFiles == nil {
+		if err := checkSecretDir(t.Path); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	_, err := t.isPathOK()
+	return err
+}
+
+// publish writes the target file and schedules its deletion
+func (t fileSink) publish(kv map[string]interface{}) error {
+	if err := t.write(kv); err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if t.DeleteOnRead {
+		if err := destroySecretFileOnRead(t.Path, t.AvailableTime); err != nil {
+			_ = destroySecretFile(t.Path, "0")
+			return errors.Wrap(errors.WithStack(err), errInfo())
+		}
+		log.Infof("Secret file: %v, will be removed once read, at most in %v seconds", t.Path, t.AvailableTime)
+		return nil
+	}
+	if err := destroySecretFile(t.Path, t.AvailableTime); err != nil {
+		_ = destroySecretFile(t.Path, "0")
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	log.Infof("Secret file: %v, will be removed in %v seconds", t.Path, t.AvailableTime)
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_secretTarget_replace(t *testing.T) {
+	mySecret := map[string]string{"secret": "value"}
+	tests := []struct {
+		name        string
+		kv          map[string]string
+		wantChanged bool
+		wantContent string
+	}{
+		{"create", mySecret, true, "secret=value\n"},
+		{"unchanged", mySecret, false, "secret=value\n"},
+		{"changed", map[string]string{"secret": "new", "other": "value"}, true, "other=value\nsecret=new\n"},
+	}
+	tg := &secretTarget{Path: "./test.replace", Format: formatKV}
+	defer os.Remove(tg.Path)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotChanged, err := tg.replace(secretsFromStrings(tt.kv))
+			if err != nil {
+				t.Errorf("secretTarget.replace() error = %v", err)
+				return
+			}
+			if gotChanged != tt.wantChanged {
+				t.Errorf("secretTarget.replace() = %v, want %v", gotChanged, tt.wantChanged)
+			}
+			data, _ := ioutil.ReadFile(tg.Path)
+			if string(data) != tt.wantContent {
+				t.Errorf("secretTarget.replace() content = %q, want %q", data, tt.wantContent)
+			}
+		})
+	}
+}
+func Test_fileSink_publish(t *testing.T) {
+	tests := []struct {
+		name          string
+		availableTime string
+		wantErr       bool
+	}{
+		{"immediate", "0", false},
+		{"noReaper", "invalid", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := fileSink{&secretTarget{Type: targetFile, Path: "./test.publish", Format: formatKV,
+				AvailableTime: tt.availableTime}}
+			defer os.Remove(s.Path)
+			if err := s.publish(secretsFromStrings(map[string]string{"secret": "value"})); (err != nil) != tt.wantErr {
+				t.Errorf("fileSink.publish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// the file is deleted when no reaper is scheduled
+			if _, err := os.Stat(s.Path); !os.IsNotExist(err) {
+				t.Errorf("fileSink.publish() file %v not removed", s.Path)
+			}
+		})
+	}
+}
+
+func Test_secretTarget_render(t *testing.T) {
+	mySecret := map[string]string{"secret": "value", "other": "value2"}
+	tests := []struct {
+		name   string
+		format string
+		pretty bool
+		want   string
+	}{
+		{"yaml", formatYAML, false, "other: value2\nsecret: value\n"},
+		{"kv", formatKV, false, "other=value2\nsecret=value\n"},
+		{"json", formatJSON, false, `{"other":"value2","secret":"value"}` + "\n"},
+		{"dotenv", formatDotenv, false, "other=\"value2\"\nsecret=\"value\"\n"},
+		{"shell", formatShell, false, "export other='value2'\nexport secret='value'\n"},
+		{"jsonPretty", formatJSON, true, "{\n  \"other\": \"value2\",\n  \"secret\": \"value\"\n}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := &secretTarget{Format: tt.format, Pretty: tt.pretty}
+			got, err := tg.render(secretsFromStrings(mySecret))
+			if err != nil {
+				t.Errorf("secretTarget.render() error = %v", err)
+				return
+			}
+			if string(got) != tt.want {
+				t.Errorf("secretTarget.render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_secretTarget_render_nested(t *testing.T) {
+	secrets := map[string]interface{}{
+		"port": int64(5432),
+		"db":   map[string]interface{}{"hosts": []interface{}{"a", "b"}, "tls": true},
+	}
+	tests := []struct {
+		name    string
+		format  string
+		flatten string
+		want    string
+	}{
+		{"yaml", formatYAML, flattenDot, "db:\n  hosts:\n  - a\n  - b\n  tls: true\nport: 5432\n"},
+		{"json", formatJSON, flattenDot, `{"db":{"hosts":["a","b"],"tls":true},"port":5432}` + "\n"},
+		{"kvDot", formatKV, flattenDot, "db.hosts.0=a\ndb.hosts.1=b\ndb.tls=true\nport=5432\n"},
+		{"kvUnderscore", formatKV, flattenUnderscore, "DB__HOSTS__0=a\nDB__HOSTS__1=b\nDB__TLS=true\nport=5432\n"},
+		{"dotenv", formatDotenv, flattenDot, "db_hosts_0=\"a\"\ndb_hosts_1=\"b\"\ndb_tls=\"true\"\nport=\"5432\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := &secretTarget{Format: tt.format, Flatten: tt.flatten}
+			got, err := tg.render(secrets)
+			if err != nil {
+				t.Errorf("secretTarget.render() error = %v", err)
+				return
+			}
+			if string(got) != tt.want {
+				t.Errorf("secretTarget.render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_secretTarget_render_binary(t *testing.T) {
+	secrets := map[string]interface{}{"keytab": []byte{0, 1, 2}}
+	for _, format := range []string{formatYAML, formatJSON, formatKV, formatDotenv, formatShell} {
+		tg := &secretTarget{Format: format}
+		if _, err := tg.render(secrets); err == nil {
+			t.Errorf("secretTarget.render() %v binary error = nil", format)
+		}
+	}
+}
+
+func Test_readTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []*secretTarget
+		wantErr bool
+	}{
+		{"ok", `
+- path: /dev/shm/app.json
+  format: json
+  mode: "0400"
+  availabletime: 120
+- type: socket
+  path: /dev/shm/app.sock
+  alloweduids: [1000]
+- type: env
+`, []*secretTarget{
+			{Type: targetFile, Path: "/dev/shm/app.json", Format: formatJSON, Flatten: flattenDot, Mode: "0400", AvailableTime: "120", AllowedUIDs: []int{os.Getuid()}},
+			{Type: targetSocket, Path: "/dev/shm/app.sock", Format: formatYAML, Flatten: flattenDot, AvailableTime: "60", AllowedUIDs: []int{1000}},
+			{Type: targetEnv},
+		}, false},
+		{"maxAvailableTime", "- path: /dev/shm/gvs\n  availabletime: 300\n", []*secretTarget{
+			{Type: targetFile, Path: "/dev/shm/gvs", Format: formatYAML, Flatten: flattenDot, AvailableTime: "180", AllowedUIDs: []int{os.Getuid()}},
+		}, false},
+		{"empty", "", nil, true},
+		{"unknownField", "- path: /dev/shm/gvs\n  foo: bar\n", nil, true},
+		{"noPath", "- format: json\n", nil, true},
+		{"wrongType", "- type: http\n  path: /dev/shm/gvs\n", nil, true},
+		{"wrongFormat", "- path: /dev/shm/gvs\n  format: xml\n", nil, true},
+		{"wrongMode", "- path: /dev/shm/gvs\n  mode: rw\n", nil, true},
+		{"wrongFlatten", "- path: /dev/shm/gvs\n  flatten: slash\n", nil, true},
+		{"deleteOnReadSocket", "- type: socket\n  path: /dev/shm/gvs\n  deleteonread: true\n", nil, true},
+		{"dirSocket", "- type: socket\n  path: /dev/shm/gvs\n  format: dir\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "./test.targets"
+			_ = ioutil.WriteFile(path, []byte(tt.content), 0600)
+			defer os.Remove(path)
+			got, err := readTargets(path, "60")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readTargets() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readTargets() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkTargets(t *testing.T) {
+	file := &secretTarget{Type: targetFile, Path: "./test.check"}
+	socket := &secretTarget{Type: targetSocket, Path: "./test.check"}
+	env := &secretTarget{Type: targetEnv}
+	onRead := &secretTarget{Type: targetFile, Path: "./test.check", DeleteOnRead: true}
+	tests := []struct {
+		name    string
+		targets []*secretTarget
+		mode    string
+		wantErr bool
+	}{
+		{"publish", []*secretTarget{file, socket}, modePublish, false},
+		{"publishEnv", []*secretTarget{file, env}, modePublish, true},
+		{"exec", []*secretTarget{file, env}, modeExec, false},
+		{"execSocket", []*secretTarget{socket, env}, modeExec, true},
+		{"daemon", []*secretTarget{file}, modeDaemon, false},
+		{"daemonEnv", []*secretTarget{file, env}, modeDaemon, true},
+		{"daemonDeleteOnRead", []*secretTarget{onRead}, modeDaemon, true},
+		{"supervise", []*secretTarget{file, env}, modeSupervise, false},
+		{"superviseDeleteOnRead", []*secretTarget{onRead}, modeSupervise, true},
+		{"fileKO", []*secretTarget{{Type: targetFile, Path: "/notexist/gvs"}}, modePublish, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkTargets(tt.targets, tt.mode); (err != nil) != tt.wantErr {
+				t.Errorf("checkTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_gvs_targets(t *testing.T) {
+	g := &gvs{SecretFilePath: "/dev/shm/gvs"}
+	tests := []struct {
+		name         string
+		mode         string
+		reloadSignal string
+		wantTypes    []string
+	}{
+		{"publish", modePublish, "", []string{targetFile}},
+		{"exec", modeExec, "", []string{targetEnv}},
+		{"supervise", modeSupervise, "", []string{targetEnv}},
+		{"superviseSignal", modeSupervise, "SIGHUP", []string{targetEnv, targetFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g.ReloadSignal = tt.reloadSignal
+			var gotTypes []string
+			for _, tg := range g.targets(tt.mode) {
+				if len(tg.Type) == 0 {
+					tg.Type = targetFile
+				}
+				gotTypes = append(gotTypes, tg.Type)
+			}
+			if !reflect.DeepEqual(gotTypes, tt.wantTypes) {
+				t.Errorf("gvs.targets() = %v, want %v", gotTypes, tt.wantTypes)
+			}
+		})
+	}
+}
+
+func Test_secretTarget_write(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		wantMode os.FileMode
+	}{
+		{"mode0400", "0400", 0400},
+		{"mode0640", "0640", 0640},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := &secretTarget{Path: "./test.mode", Format: formatJSON, Mode: tt.mode}
+			defer os.Remove(tg.Path)
+			if err := tg.write(map[string]interface{}{"secret": "value"}); err != nil {
+				t.Errorf("secretTarget.write() error = %v", err)
+				return
+			}
+			fi, err := os.Stat(tg.Path)
+			if err != nil {
+				t.Errorf("secretTarget.write() %v", err)
+				return
+			}
+			if fi.Mode().Perm() != tt.wantMode {
+				t.Errorf("secretTarget.write() mode = %v, want %v", fi.Mode().Perm(), tt.wantMode)
+			}
+		})
+	}
+}
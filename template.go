@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// templateFuncs are the helper functions available in secret templates
+var templateFuncs = template.FuncMap{
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"base64Decode": func(s string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(s)
+		return string(b), err
+	},
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// default returns value, or def if value is empty
+	"default": func(def string, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return def
+		}
+		return value
+	},
+	// required fails the rendering when value is empty
+	"required": func(msg string, value interface{}) (interface{}, error) {
+		if value == nil || value == "" {
+			return nil, errors.New(msg)
+		}
+		return value, nil
+	},
+	"env": os.Getenv,
+}
+
+// newSecretTemplate reads and parses the Go text/template at path.
+// Referencing a missing key makes the rendering fail.
+func newSecretTemplate(path string) (*template.Template, error) {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	tmpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	return tmpl, nil
+}
+
+// renderTemplate executes the template with the secrets as data,
+// binary values being passed as raw strings
+func renderTemplate(tmpl *template.Template, kv map[string]interface{}) ([]byte, error) {
+	data := make(map[string]interface{}, len(kv))
+	for k, v := range kv {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		data[k] = v
+	}
+	var output bytes.Buffer
+	if err := tmpl.Execute(&output, data); err != nil {
+		return nil, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	return output.Bytes(), nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_renderTemplate(t *testing.T) {
+	mySecret := map[string]string{"DB_PASSWORD": "s3cr3t", "my-first-secret": "value"}
+	os.Setenv("GVS_TEST_TEMPLATE", "from-env")
+	tests := []struct {
+		name         string
+		template     string
+		want         string
+		wantParseErr bool
+		wantErr      bool
+	}{
+		{"simple", "password={{ .DB_PASSWORD }}", "password=s3cr3t", false, false},
+		{"index", `first={{ index . "my-first-secret" }}`, "first=value", false, false},
+		{"funcs", `{{ base64 .DB_PASSWORD }} {{ base64Decode "czNjcjN0" }} {{ json .DB_PASSWORD }} {{ env "GVS_TEST_TEMPLATE" }}`,
+			`czNjcjN0 s3cr3t "s3cr3t" from-env`, false, false},
+		{"default", `{{ default "none" (index . "missing") }}`, "none", false, false},
+		{"missingKey", "{{ .MISSING }}", "", false, true},
+		{"required", `{{ required "missing key" (index . "missing") }}`, "", false, true},
+		{"parseError", "{{ .DB_PASSWORD ", "", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "./test.tmpl"
+			_ = ioutil.WriteFile(path, []byte(tt.template), 0600)
+			defer os.Remove(path)
+			tmpl, err := newSecretTemplate(path)
+			if (err != nil) != tt.wantParseErr {
+				t.Errorf("newSecretTemplate() error = %v, wantErr %v", err, tt.wantParseErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			got, err := renderTemplate(tmpl, secretsFromStrings(mySecret))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("renderTemplate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if string(got) != tt.want {
+				t.Errorf("renderTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	vault "github.com/mch1307/vaultlib"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// the token file is readable by its owner only
+const tokenFileMode = "0400"
+
+// delay before renewing the token again when the renewal failed
+const tokenRenewRetryInterval = 10 * time.Second
+
+// sunkToken is the Vault token handed over to the application
+type sunkToken struct {
+	Token    string `json:"token"`
+	Accessor string `json:"accessor"`
+	TTL      int    `json:"ttl"`
+	// token is a response wrapping token, holding the gvs token, accessor
+	// and ttl, to be unwrapped with sys/wrapping/unwrap
+	Wrapped bool `json:"wrapped,omitempty"`
+}
+
+// sinkToken returns the gvs Vault token, response wrapped when
+// TokenWrapTTL is set
+func (g *gvs) sinkToken() (sunkToken, error) {
+	info := g.tokenInfo()
+	token := sunkToken{Token: info.ID, Accessor: info.Accessor, TTL: info.TTL}
+	if len(g.TokenWrapTTL) == 0 {
+		return token, nil
+	}
+	var wrap struct {
+		WrapInfo struct {
+			Token    string `json:"token"`
+			Accessor string `json:"accessor"`
+			TTL      int    `json:"ttl"`
+		} `json:"wrap_info"`
+	}
+	header := map[string]string{"X-Vault-Token": info.ID, "X-Vault-Wrap-TTL": g.TokenWrapTTL}
+	if err := vaultCall(g.vaultHTTPClient(), "POST", g.VaultURL, "/v1/sys/wrapping/wrap", header, token, &wrap); err != nil {
+		return token, errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	if len(wrap.WrapInfo.Token) == 0 {
+		return token, errors.New("Vault returned no wrapping token")
+	}
+	return sunkToken{wrap.WrapInfo.Token, wrap.WrapInfo.Accessor, wrap.WrapInfo.TTL, true}, nil
+}
+
+// writeTokenFile writes the gvs Vault token as json to the TokenFilePath
+// file, readable by its owner only. When expire is true (publish and exec
+// modes), the file is removed after SecretAvailabletime seconds, otherwise
+// it is kept until gvs stops.
+func (g *gvs) writeTokenFile(expire bool) error {
+	token, err := g.sinkToken()
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	output, _ := json.Marshal(token)
+	t := &secretTarget{Type: targetFile, Path: g.TokenFilePath, Mode: tokenFileMode}
+	if _, err := t.replaceFile(t.Path, append(output, '\n')); err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	g.TokenHandedOff = true
+	if !expire {
+		log.Infof("Vault token file: %v, will be removed at shutdown", t.Path)
+		return nil
+	}
+	if err := destroySecretFile(t.Path, g.SecretAvailabletime); err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	log.Infof("Vault token file: %v, will be removed in %v seconds", t.Path, g.SecretAvailabletime)
+	return nil
+}
+
+// removeTokenFile securely deletes the token file, logging the outcome
+func (g *gvs) removeTokenFile() {
+	t := &secretTarget{Type: targetFile, Path: g.TokenFilePath}
+	t.remove()
+}
+
+// tokenEnv returns the VAULT_ADDR and VAULT_TOKEN variables passed to the
+// child process, when TokenEnv is set
+func (g *gvs) tokenEnv() map[string]string {
+	if !g.TokenEnv {
+		return nil
+	}
+	g.TokenHandedOff = true
+	return map[string]string{envVaultAddress: g.VaultURL, envVaultToken: g.tokenInfo().ID}
+}
+
+// revokeToken revokes the gvs Vault token at the end of the run, so that it
+// does not outlive gvs, unless KeepToken is set, the token was provided
+// (token auth method) or handed over to the application.
+// The leases of the dynamic secrets read are revoked first when revokeLeases
+// is true (shutdown, errors), otherwise the token is kept alive as revoking
+// it would revoke the published dynamic secrets.
+func (g *gvs) revokeToken(revokeLeases bool) {
+	if g.TokenRevoked || g.KeepToken || g.AuthMethod == authToken {
+		return
+	}
+	if revokeLeases {
+		g.revokeLeases(g.Leases)
+	}
+	switch {
+	case len(g.Leases) > 0:
+		log.Infof("Vault token kept alive, holding the leases of %v published dynamic secret(s)", len(g.Leases))
+		return
+	case g.TokenHandedOff:
+		log.Infof("Vault token kept alive, handed over to the application")
+		return
+	}
+	if err := g.vaultCall("POST", "/v1/auth/token/revoke-self", nil, nil); err != nil {
+		log.Warnf("Error revoking Vault token: %v", err)
+		return
+	}
+	g.tokenLock.Lock()
+	g.TokenRevoked = true
+	g.tokenLock.Unlock()
+	log.Infof("Vault token revoked")
+}
+
+// revokeLeases revokes the given leases and forgets them
+func (g *gvs) revokeLeases(leases []string) {
+	revoked := make(map[string]bool, len(leases))
+	for _, lease := range leases {
+		revoked[lease] = true
+		if err := g.vaultCall("POST", "/v1/sys/leases/revoke", map[string]string{"lease_id": lease}, nil); err != nil {
+			log.Warnf("Error revoking lease %v: %v", lease, err)
+			continue
+		}
+		log.Debugf("Lease %v revoked", lease)
+	}
+	var kept []string
+	for _, lease := range g.Leases {
+		if !revoked[lease] {
+			kept = append(kept, lease)
+		}
+	}
+	g.Leases = kept
+}
+
+// revokeStaleLeases revokes the leases of the superseded dynamic secrets once
+// their leaseRevokeDelay is elapsed
+func (g *gvs) revokeStaleLeases() {
+	var due []string
+	var pending []staleLease
+	for _, lease := range g.staleLeases {
+		if time.Now().Before(lease.revokeAt) {
+			pending = append(pending, lease)
+			continue
+		}
+		due = append(due, lease.id)
+	}
+	g.staleLeases = pending
+	if len(due) > 0 {
+		g.revokeLeases(due)
+	}
+}
+
+// vaultCall calls the Vault API path with the gvs token
+func (g *gvs) vaultCall(method, path string, payload, result interface{}) error {
+	header := map[string]string{"X-Vault-Token": g.tokenInfo().ID}
+	return vaultCall(g.vaultHTTPClient(), method, g.VaultURL, path, header, payload, result)
+}
+
+// vaultHTTPClient returns the http client of the Vault requests, the default
+// one when gvs was not initialized by newGVS
+func (g *gvs) vaultHTTPClient() *http.Client {
+	if g.HTTPCli == nil {
+		return http.DefaultClient
+	}
+	return g.HTTPCli
+}
+
+// tokenInfo returns the gvs Vault token information
+func (g *gvs) tokenInfo() *vault.VaultTokenInfo {
+	g.tokenLock.Lock()
+	defer g.tokenLock.Unlock()
+	if g.TokenInfo == nil {
+		return &vault.VaultTokenInfo{}
+	}
+	return g.TokenInfo
+}
+
+// lookupToken reads the information of the token from Vault and makes it
+// the gvs token
+func (g *gvs) lookupToken(token string) error {
+	var lookup struct {
+		Data vault.VaultTokenInfo `json:"data"`
+	}
+	header := map[string]string{"X-Vault-Token": token}
+	if err := vaultCall(g.vaultHTTPClient(), "GET", g.VaultURL, "/v1/auth/token/lookup-self", header, nil, &lookup); err != nil {
+		return errors.Wrap(errors.WithStack(err), errInfo())
+	}
+	g.tokenLock.Lock()
+	g.TokenInfo = &lookup.Data
+	g.tokenLock.Unlock()
+	return nil
+}
+
+// useLoginToken makes the token issued by the login the gvs token. As no
+// revocation is scheduled yet, the token is revoked when it cannot be looked
+// up, rather than staying alive until its TTL.
+func (g *gvs) useLoginToken(token string) error {
+	err := g.lookupToken(token)
+	if err == nil {
+		return nil
+	}
+	g.tokenLock.Lock()
+	g.TokenInfo = &vault.VaultTokenInfo{ID: token}
+	g.tokenLock.Unlock()
+	g.revokeToken(false)
+	return errors.Wrap(errors.WithStack(err), errInfo())
+}
+
+// renewToken renews the gvs Vault token when two thirds of its TTL are
+// elapsed, until it is revoked. The token information is replaced each
+// time the token is renewed.
+func (g *gvs) renewToken() {
+	delay := time.Duration(g.tokenInfo().TTL) * time.Second * 2 / 3
+	for {
+		time.Sleep(delay)
+		g.tokenLock.Lock()
+		revoked := g.TokenRevoked
+		g.tokenLock.Unlock()
+		if revoked {
+			return
+		}
+		token := g.tokenInfo().ID
+		err := g.vaultCall("POST", "/v1/auth/token/renew-self", nil, nil)
+		if err == nil {
+			err = g.lookupToken(token)
+		}
+		if err != nil {
+			log.Warnf("Error renewing Vault token, retrying in %v: %v", tokenRenewRetryInterval, err)
+			delay = tokenRenewRetryInterval
+			continue
+		}
+		log.Debugf("Vault token renewed")
+		delay = time.Duration(g.tokenInfo().TTL) * time.Second * 2 / 3
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_gvs_sinkToken(t *testing.T) {
+	v := newFakeVault()
+	defer v.Close()
+	plain := sunkToken{fakeVaultToken, "fake-accessor", 300, false}
+	tests := []struct {
+		name    string
+		wrapTTL string
+		want    sunkToken
+		wantErr bool
+	}{
+		{"plain", "", plain, false},
+		{"wrapped", "60s", sunkToken{"s.wrap1", "wrap-accessor", 60, true}, false},
+		{"wrongTTL", "forever", sunkToken{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := v.gvs(t)
+			g.TokenWrapTTL = tt.wrapTTL
+			got, err := g.sinkToken()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("gvs.sinkToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("gvs.sinkToken() = %+v, want %+v", got, tt.want)
+			}
+			if got.Wrapped {
+				unwrapped, _ := json.Marshal(v.wrapped[got.Token].data)
+				var token sunkToken
+				_ = json.Unmarshal(unwrapped, &token)
+				if token != plain {
+					t.Errorf("gvs.sinkToken() wrapped %+v, want %+v", token, plain)
+				}
+			}
+		})
+	}
+}
+
+func Test_gvs_writeTokenFile(t *testing.T) {
+	v := newFakeVault()
+	defer v.Close()
+	g := v.gvs(t)
+	g.TokenFilePath = "./test.token"
+	g.SecretAvailabletime = "0"
+	defer os.Remove(g.TokenFilePath)
+	if err := g.writeTokenFile(false); err != nil {
+		t.Fatalf("gvs.writeTokenFile() error = %v", err)
+	}
+	fi, err := os.Stat(g.TokenFilePath)
+	if err != nil {
+		t.Fatalf("token file not written: %v", err)
+	}
+	if fi.Mode().Perm() != 0400 {
+		t.Errorf("token file mode = %v, want 0400", fi.Mode().Perm())
+	}
+	data, _ := ioutil.ReadFile(g.TokenFilePath)
+	var got sunkToken
+	if err := json.Unmarshal(data, &got); err != nil || got.Token != fakeVaultToken {
+		t.Errorf("token file = %s, want token %v", data, fakeVaultToken)
+	}
+	if err := g.writeTokenFile(true); err != nil {
+		t.Fatalf("gvs.writeTokenFile() error = %v", err)
+	}
+	if _, err := os.Stat(g.TokenFilePath); !os.IsNotExist(err) {
+		t.Errorf("token file not removed after the available time")
+	}
+}
+
+func Test_gvs_tokenEnv(t *testing.T) {
+	v := newFakeVault()
+	defer v.Close()
+	g := v.gvs(t)
+	if got := g.tokenEnv(); got != nil {
+		t.Errorf("gvs.tokenEnv() = %v, want nil", got)
+	}
+	g.TokenEnv = true
+	want := map[string]string{"VAULT_ADDR": v.URL, "VAULT_TOKEN": fakeVaultToken}
+	if got := g.tokenEnv(); !reflect.DeepEqual(got, want) {
+		t.Errorf("gvs.tokenEnv() = %v, want %v", got, want)
+	}
+}
+
+func Test_gvs_revokeToken(t *testing.T) {
+	v := newFakeVault()
+	defer v.Close()
+	tests := []struct {
+		name         string
+		keepToken    bool
+		authMethod   string
+		handedOff    bool
+		dynamic      bool
+		revokeLeases bool
+		want         []string
+	}{
+		{"revoked", false, authAppRole, false, false, false, []string{"fake-accessor"}},
+		{"keepToken", true, authAppRole, false, false, true, nil},
+		{"tokenAuth", false, authToken, false, false, true, nil},
+		{"handedOff", false, authAppRole, true, false, false, nil},
+		{"publishedLeases", false, authAppRole, false, true, false, nil},
+		{"leases", false, authAppRole, false, true, true, []string{"database/creds/my-app/1", "fake-accessor"}},
+		{"handedOffLeases", false, authAppRole, true, true, true, []string{"database/creds/my-app/1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v.revoked, v.leases = nil, 0
+			g := v.gvs(t)
+			g.KeepToken, g.AuthMethod, g.TokenHandedOff = tt.keepToken, tt.authMethod, tt.handedOff
+			if tt.dynamic {
+				if _, _, err := g.readVaultSecret("database/creds/my-app", 0); err != nil {
+					t.Fatalf("gvs.readVaultSecret() error = %v", err)
+				}
+			}
+			g.revokeToken(tt.revokeLeases)
+			// revoking again is a no-op
+			g.revokeToken(tt.revokeLeases)
+			if !reflect.DeepEqual(v.revoked, tt.want) {
+				t.Errorf("gvs.revokeToken() revoked %v, want %v", v.revoked, tt.want)
+			}
+		})
+	}
+}
+
+func Test_gvs_useLoginToken(t *testing.T) {
+	v := newFakeVault()
+	defer v.Close()
+	tests := []struct {
+		name        string
+		authMethod  string
+		lookupFails bool
+		wantErr     bool
+		want        []string
+	}{
+		{"lookedUp", authAppRole, false, false, nil},
+		{"lookupFails", authAppRole, true, true, []string{"fake-accessor"}},
+		{"tokenAuth", authToken, true, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v.revoked, v.lookupFails = nil, tt.lookupFails
+			g := &gvs{VaultURL: v.URL, HTTPCli: v.Client(), AuthMethod: tt.authMethod}
+			if err := g.useLoginToken(fakeVaultToken); (err != nil) != tt.wantErr {
+				t.Errorf("gvs.useLoginToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(v.revoked, tt.want) {
+				t.Errorf("gvs.useLoginToken() revoked %v, want %v", v.revoked, tt.want)
+			}
+		})
+	}
+}